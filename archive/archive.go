@@ -0,0 +1,434 @@
+// Package archive creates and extracts the zip archives of go-offline-packager.
+//
+// Entry names are always written with forward slashes and converted to the
+// path separator of the operating system on extraction, so archives can be
+// exchanged between Windows and other platforms. Extraction rejects entries
+// which would be written outside of the destination folder (Zip Slip).
+package archive
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrIllegalPath is returned for entries which would be extracted outside of the destination folder.
+var ErrIllegalPath = errors.New("illegal file path in archive")
+
+// Compression selects how the entries of an archive are compressed.
+type Compression int
+
+const (
+	// CompressionDefault deflates the entries with the default level.
+	CompressionDefault Compression = iota
+	// CompressionStore stores the entries uncompressed, which is fastest for
+	// content which is already compressed like module zips.
+	CompressionStore
+	// CompressionFast deflates the entries with the fastest level.
+	CompressionFast
+	// CompressionBest deflates the entries with the best compression.
+	CompressionBest
+)
+
+// Options controls which files Create adds to the archive.
+type Options struct {
+	// Compression of the entries.
+	Compression Compression
+	// Include reports whether a file is added by its slash separated path
+	// relative to the archived directory, nil adds all files.
+	Include func(name string) bool
+	// OnAdd is called for every file added to the archive.
+	OnAdd func(name string, size int64)
+	// Reproducible writes every entry with the fixed modification time ReproducibleTime
+	// and the permissions 0644 or 0755 for executables, so the same files always result
+	// in the same archive. The entries are always written in lexical order.
+	Reproducible bool
+	// OnError is called for every file which can't be added. The file is left out
+	// if it returns nil, otherwise Create fails with the returned error. If OnError
+	// is nil, Create fails at the first error.
+	OnError func(name string, err error) error
+}
+
+// ReproducibleTime is the modification time of all entries of a reproducible archive, the
+// earliest time which can be represented in the MS-DOS format of zip.
+var ReproducibleTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Create writes the content of dir as zip archive to w.
+func Create(dir string, w io.Writer, opts Options) (err error) {
+	zw, method := newWriter(w, opts.Compression)
+	defer func() {
+		if cerr := zw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	return walkFiles(dir, opts, func(file, name string, info os.FileInfo) error {
+		return addFile(zw, file, name, method, opts.Reproducible)
+	})
+}
+
+const (
+	// entryOverhead is the upper bound of the local header, data descriptor and central
+	// directory record of an entry, without the name which is contained in both headers.
+	entryOverhead = 256
+	// endOverhead is the upper bound of the (zip64) end of central directory records.
+	endOverhead = 128
+)
+
+// CreateVolumes writes the content of dir as zip archives of at most volumeSize bytes. The
+// writer of volume n (starting at 1) is returned by next and closed after the volume is
+// complete. Files are never split, so every volume is a standalone archive, a file which
+// doesn't fit into an empty volume is an error. It returns the number of volumes written.
+func CreateVolumes(dir string, volumeSize int64, next func(n int) (io.WriteCloser, error), opts Options) (n int, err error) {
+	var (
+		wc      io.WriteCloser
+		cw      *countWriter
+		zw      *zip.Writer
+		method  uint16
+		central int64
+	)
+	closeVolume := func() error {
+		if zw == nil {
+			return nil
+		}
+		err := zw.Close()
+		if cerr := wc.Close(); err == nil {
+			err = cerr
+		}
+		zw = nil
+		return err
+	}
+	defer func() {
+		if cerr := closeVolume(); err == nil {
+			err = cerr
+		}
+	}()
+
+	err = walkFiles(dir, opts, func(file, name string, info os.FileInfo) error {
+		// Deflate adds at most 5 bytes per stored block of 64KiB to incompressible content
+		size := info.Size() + info.Size()/10000 + 2*int64(len(name)) + entryOverhead
+		if size+endOverhead > volumeSize {
+			return fmt.Errorf("%v doesn't fit into a volume of %v bytes", name, volumeSize)
+		}
+
+		if zw != nil {
+			if err := zw.Flush(); err != nil {
+				return err
+			}
+			if cw.n+central+size+endOverhead > volumeSize {
+				if err := closeVolume(); err != nil {
+					return err
+				}
+			}
+		}
+		if zw == nil {
+			n++
+			w, err := next(n)
+			if err != nil {
+				return err
+			}
+			wc, cw, central = w, &countWriter{w: w}, 0
+			zw, method = newWriter(cw, opts.Compression)
+		}
+
+		central += int64(len(name)) + entryOverhead/2
+		return addFile(zw, file, name, method, opts.Reproducible)
+	})
+	return n, err
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// newWriter returns a zip writer for w with the compressor of the given compression and the
+// compression method of the entries.
+func newWriter(w io.Writer, compression Compression) (*zip.Writer, uint16) {
+	zw := zip.NewWriter(w)
+	method := zip.Deflate
+	switch compression {
+	case CompressionStore:
+		method = zip.Store
+	case CompressionFast, CompressionBest:
+		level := flate.BestSpeed
+		if compression == CompressionBest {
+			level = flate.BestCompression
+		}
+		zw.RegisterCompressor(zip.Deflate, func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		})
+	}
+	return zw, method
+}
+
+// walkFiles calls add for every file of dir in lexical order, which is included by opts.
+func walkFiles(dir string, opts Options, add func(file, name string, info os.FileInfo) error) error {
+	onError := opts.OnError
+	if onError == nil {
+		onError = func(name string, err error) error { return err }
+	}
+
+	return filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return onError(file, err)
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath, err := filepath.Rel(dir, file)
+		if err != nil {
+			return onError(file, err)
+		}
+		name := filepath.ToSlash(relPath)
+		if opts.Include != nil && !opts.Include(name) {
+			return nil
+		}
+
+		if err := add(file, name, info); err != nil {
+			return onError(name, fmt.Errorf("failed to add %v: %w", name, err))
+		}
+		if opts.OnAdd != nil {
+			opts.OnAdd(name, info.Size())
+		}
+		return nil
+	})
+}
+
+// AddFile adds file to the archive as entry name, preserving its permissions and
+// modification time. Backslashes in name are converted to forward slashes.
+func AddFile(zw *zip.Writer, file, name string) error {
+	return addFile(zw, file, name, zip.Deflate, false)
+}
+
+func addFile(zw *zip.Writer, file, name string, method uint16, reproducible bool) error {
+	reader, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	fiStat, err := reader.Stat()
+	if err != nil {
+		return err
+	}
+
+	fh, err := zip.FileInfoHeader(fiStat)
+	if err != nil {
+		return err
+	}
+	fh.Name = EntryName(name)
+	fh.Method = method
+	if reproducible {
+		fh.Modified = ReproducibleTime
+		mode := os.FileMode(0644)
+		if fiStat.Mode()&0111 != 0 {
+			mode = 0755
+		}
+		fh.SetMode(mode)
+	}
+
+	writer, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
+
+// EntryName returns the slash separated and cleaned entry name of name, entries written
+// with backslashes (ex. by tools on Windows) are converted to forward slashes.
+func EntryName(name string) string {
+	return strings.TrimLeft(path.Clean(strings.ReplaceAll(name, "\\", "/")), "/")
+}
+
+// TargetPath returns the path the entry name is extracted to in dst. Names written
+// with backslashes on Windows are handled as well. It fails with ErrIllegalPath if the
+// entry is absolute or would be extracted outside of dst.
+func TargetPath(dst, name string) (string, error) {
+	slashed := strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(slashed) || filepath.IsAbs(filepath.FromSlash(slashed)) || filepath.VolumeName(filepath.FromSlash(slashed)) != "" {
+		return "", fmt.Errorf("%w: %v", ErrIllegalPath, name)
+	}
+
+	target := filepath.Join(dst, filepath.FromSlash(slashed))
+	if target != filepath.Clean(dst) && !strings.HasPrefix(target, filepath.Clean(dst)+string(filepath.Separator)) {
+		return "", fmt.Errorf("%w: %v", ErrIllegalPath, name)
+	}
+	return target, nil
+}
+
+// ExtractOptions controls which entries ExtractReader extracts.
+type ExtractOptions struct {
+	// Include reports whether a file entry is extracted by its slash separated
+	// and cleaned name, nil extracts all entries.
+	Include func(name string) bool
+	// OnIllegalPath is called for every entry which would be extracted outside of
+	// the destination folder. The entry is skipped if it returns nil, otherwise the
+	// extraction fails with the returned error. If OnIllegalPath is nil, the
+	// extraction fails with ErrIllegalPath.
+	OnIllegalPath func(name string, err error) error
+}
+
+// Extract extracts the zip archive src into the folder dst, which is created if it doesn't exist.
+func Extract(src, dst string, opts ExtractOptions) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	_, err = ExtractReader(&zr.Reader, dst, opts)
+	return err
+}
+
+// ExtractReader extracts the entries of zr selected by opts into the folder dst. Directories are
+// created upfront and in order, so the files can be extracted in parallel without racing on the
+// creation of shared parent directories. It returns the number of extracted files.
+func ExtractReader(zr *zip.Reader, dst string, opts ExtractOptions) (int, error) {
+	dst, err := filepath.Abs(dst)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(dst, 0777); err != nil {
+		return 0, err
+	}
+
+	type job struct {
+		f      *zip.File
+		target string
+	}
+
+	dirSet := map[string]struct{}{}
+	var jobs []job
+	for _, f := range zr.File {
+		isDir := strings.HasSuffix(strings.ReplaceAll(f.Name, "\\", "/"), "/")
+		if opts.Include != nil && !isDir && !opts.Include(EntryName(f.Name)) {
+			continue
+		}
+
+		target, err := TargetPath(dst, f.Name)
+		if err != nil {
+			if opts.OnIllegalPath == nil {
+				return 0, err
+			}
+			if err := opts.OnIllegalPath(f.Name, err); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		if isDir {
+			if opts.Include == nil {
+				dirSet[target] = struct{}{}
+			}
+			continue
+		}
+		dirSet[filepath.Dir(target)] = struct{}{}
+		jobs = append(jobs, job{f: f, target: target})
+	}
+
+	dirs := make([]string, 0, len(dirSet))
+	for d := range dirSet {
+		dirs = append(dirs, d)
+	}
+	sort.Strings(dirs)
+	for _, d := range dirs {
+		if err := os.MkdirAll(d, 0777); err != nil {
+			return 0, err
+		}
+	}
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	jobCh := make(chan job)
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobCh {
+				if err := ExtractFile(j.f, j.target); err != nil {
+					mu.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, j := range jobs {
+		jobCh <- j
+	}
+	close(jobCh)
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return len(jobs), nil
+}
+
+// ExtractFile extracts the entry f to the file dst, which must not exist. The permissions
+// and the modification time of the entry are preserved, independent of the umask.
+func ExtractFile(f *zip.File, dst string) (err error) {
+	perm := f.Mode().Perm()
+	keepPerm := perm != 0
+	if !keepPerm {
+		perm = 0666
+	}
+
+	destF, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, perm)
+	if err != nil {
+		return fmt.Errorf("failed to extract file %v: %w", f.Name, err)
+	}
+	defer func() {
+		if cerr := destF.Close(); err == nil && cerr != nil {
+			err = fmt.Errorf("failed to extract file %v: %w", f.Name, cerr)
+		}
+		if err == nil && keepPerm {
+			// The mode passed to OpenFile is subject to the umask
+			if cerr := os.Chmod(dst, perm); cerr != nil {
+				err = fmt.Errorf("failed to set permissions of %v: %w", f.Name, cerr)
+			}
+		}
+		if err == nil {
+			_ = os.Chtimes(dst, f.Modified, f.Modified)
+		}
+	}()
+
+	srcF, err := f.Open()
+	if err != nil {
+		return fmt.Errorf("failed to extract file %v: %w", f.Name, err)
+	}
+	defer srcF.Close()
+
+	if _, err := io.Copy(destF, srcF); err != nil {
+		return fmt.Errorf("failed to extract file %v: %w", f.Name, err)
+	}
+	return nil
+}
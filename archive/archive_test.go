@@ -0,0 +1,592 @@
+package archive
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// entry is a file of a crafted test archive.
+type entry struct {
+	name string
+	body string
+}
+
+// zipReader returns a reader of an archive with the entries written as is, so names
+// which Create never produces (backslashes, ..) can be tested.
+func zipReader(t *testing.T, entries ...entry) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, e := range entries {
+		w, err := zw.Create(e.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, e.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+// writeFiles creates the files of entries in dir.
+func writeFiles(t *testing.T, dir string, entries ...entry) {
+	t.Helper()
+	for _, e := range entries {
+		file := filepath.Join(dir, filepath.FromSlash(e.name))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(e.body), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readFiles returns the content of all files in dir by their slash separated relative path.
+func readFiles(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.Walk(dir, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, file)
+		files[filepath.ToSlash(rel)] = string(data)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func entryNames(zr *zip.Reader) []string {
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	return names
+}
+
+func TestTargetPath(t *testing.T) {
+	dst := t.TempDir()
+	tests := []struct {
+		name    string
+		want    string
+		illegal bool
+	}{
+		{name: "cache/download/a/@v/list", want: "cache/download/a/@v/list"},
+		{name: `cache\download\a\@v\list`, want: "cache/download/a/@v/list"},
+		{name: "a/./b/../c", want: "a/c"},
+		{name: "a/", want: "a"},
+		{name: ".", want: "."},
+		{name: "../evil", illegal: true},
+		{name: `..\evil`, illegal: true},
+		{name: "a/../../evil", illegal: true},
+		{name: "/etc/passwd", illegal: true},
+		{name: `\evil`, illegal: true},
+		{name: "..", illegal: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := TargetPath(dst, tt.name)
+			if tt.illegal {
+				if !errors.Is(err, ErrIllegalPath) {
+					t.Fatalf("TargetPath(%q) = %q, %v, want ErrIllegalPath", tt.name, got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TargetPath(%q) failed: %v", tt.name, err)
+			}
+			if want := filepath.Join(dst, filepath.FromSlash(tt.want)); got != want {
+				t.Errorf("TargetPath(%q) = %q, want %q", tt.name, got, want)
+			}
+		})
+	}
+}
+
+func TestEntryName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "a/b/c.txt", want: "a/b/c.txt"},
+		{name: `a\b\c.txt`, want: "a/b/c.txt"},
+		{name: "/a/b", want: "a/b"},
+		{name: "a//b/./c", want: "a/b/c"},
+		{name: "a/b/", want: "a/b"},
+	}
+
+	for _, tt := range tests {
+		if got := EntryName(tt.name); got != tt.want {
+			t.Errorf("EntryName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAddFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, entry{"mod.txt", "module"})
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "cache/download/a/@v/v1.0.0.mod", want: "cache/download/a/@v/v1.0.0.mod"},
+		{name: `cache\download\a\@v\v1.0.0.mod`, want: "cache/download/a/@v/v1.0.0.mod"},
+		{name: "/a/b.mod", want: "a/b.mod"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			zw := zip.NewWriter(&buf)
+			if err := AddFile(zw, filepath.Join(dir, "mod.txt"), tt.name); err != nil {
+				t.Fatal(err)
+			}
+			if err := zw.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(zr.File) != 1 || zr.File[0].Name != tt.want {
+				t.Fatalf("entries = %q, want [%q]", entryNames(zr), tt.want)
+			}
+			r, err := zr.File[0].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if data, _ := io.ReadAll(r); string(data) != "module" {
+				t.Errorf("content = %q, want %q", data, "module")
+			}
+		})
+	}
+}
+
+func TestExtractReader(t *testing.T) {
+	entries := []entry{
+		{"cache/download/a/@v/list", "v1.0.0\n"},
+		{"cache/download/a/@v/v1.0.0.mod", "module a\n"},
+		{"cache/download/b/@v/v1.0.0.mod", "module b\n"},
+		{"cache/download/b/@v/v1.0.0.zip", "zip"},
+	}
+
+	tests := []struct {
+		name    string
+		include func(string) bool
+		want    []string
+	}{
+		{name: "all", want: []string{
+			"cache/download/a/@v/list", "cache/download/a/@v/v1.0.0.mod",
+			"cache/download/b/@v/v1.0.0.mod", "cache/download/b/@v/v1.0.0.zip",
+		}},
+		{name: "include", include: func(name string) bool { return strings.HasPrefix(name, "cache/download/b/") }, want: []string{
+			"cache/download/b/@v/v1.0.0.mod", "cache/download/b/@v/v1.0.0.zip",
+		}},
+		{name: "none", include: func(string) bool { return false }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := t.TempDir()
+			n, err := ExtractReader(zipReader(t, entries...), dst, ExtractOptions{Include: tt.include})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(tt.want) {
+				t.Errorf("extracted %v files, want %v", n, len(tt.want))
+			}
+
+			files := readFiles(t, dst)
+			var got []string
+			for name := range files {
+				got = append(got, name)
+			}
+			sort.Strings(got)
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Fatalf("extracted %q, want %q", got, tt.want)
+			}
+			for _, e := range entries {
+				if body, ok := files[e.name]; ok && body != e.body {
+					t.Errorf("content of %v = %q, want %q", e.name, body, e.body)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractReaderExistingFile(t *testing.T) {
+	dst := t.TempDir()
+	writeFiles(t, dst, entry{"a/b.txt", "old"})
+
+	if _, err := ExtractReader(zipReader(t, entry{"a/b.txt", "new"}), dst, ExtractOptions{}); err == nil {
+		t.Fatal("extracting over an existing file succeeded")
+	}
+	if files := readFiles(t, dst); files["a/b.txt"] != "old" {
+		t.Errorf("existing file was overwritten: %q", files["a/b.txt"])
+	}
+}
+
+func TestCreateRoundTrip(t *testing.T) {
+	entries := []entry{
+		{"cache/download/a/@v/list", "v1.0.0\n"},
+		{"cache/download/a/@v/v1.0.0.mod", "module a\n"},
+		{"cache/download/a/@v/v1.0.0.zip", strings.Repeat("zip", 1000)},
+		{"cache/download/b/@v/v1.0.0.mod", "module b\n"},
+	}
+
+	for _, c := range []Compression{CompressionDefault, CompressionStore, CompressionFast, CompressionBest} {
+		src, dst := t.TempDir(), t.TempDir()
+		writeFiles(t, src, entries...)
+
+		var buf bytes.Buffer
+		if err := Create(src, &buf, Options{Compression: c}); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ExtractReader(zr, dst, ExtractOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		files := readFiles(t, dst)
+		if len(files) != len(entries) {
+			t.Errorf("compression %v: extracted %v files, want %v", c, len(files), len(entries))
+		}
+		for _, e := range entries {
+			if files[e.name] != e.body {
+				t.Errorf("compression %v: content of %v = %q, want %q", c, e.name, files[e.name], e.body)
+			}
+		}
+	}
+}
+
+func TestCreateOptions(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, entry{"a.txt", "a"}, entry{"b/large.txt", strings.Repeat("x", 100)}, entry{"b/small.txt", "b"})
+
+	var added []string
+	var buf bytes.Buffer
+	err := Create(src, &buf, Options{
+		Include: func(name string) bool { return name != "a.txt" },
+		OnAdd:   func(name string, size int64) { added = append(added, name) },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(entryNames(zr), ","); got != "b/large.txt,b/small.txt" {
+		t.Errorf("entries = %v, want b/large.txt,b/small.txt", got)
+	}
+	if strings.Join(added, ",") != "b/large.txt,b/small.txt" {
+		t.Errorf("added %q, want [b/large.txt b/small.txt]", added)
+	}
+}
+
+func TestCreateReproducible(t *testing.T) {
+	entries := []entry{{"b/y.txt", "y"}, {"a/x.txt", "x"}, {"c.txt", "c"}}
+	create := func() []byte {
+		src := t.TempDir()
+		writeFiles(t, src, entries...)
+		var buf bytes.Buffer
+		if err := Create(src, &buf, Options{Reproducible: true}); err != nil {
+			t.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+
+	first := create()
+	if second := create(); !bytes.Equal(first, second) {
+		t.Fatal("archives of the same files differ")
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(first), int64(len(first)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(entryNames(zr), ","); got != "a/x.txt,b/y.txt,c.txt" {
+		t.Errorf("entries = %v, want lexical order", got)
+	}
+	for _, f := range zr.File {
+		if !f.Modified.Equal(ReproducibleTime) || f.Mode().Perm() != 0644 {
+			t.Errorf("%v has time %v and mode %v, want %v and 0644", f.Name, f.Modified, f.Mode().Perm(), ReproducibleTime)
+		}
+	}
+}
+
+// volumeBuffer is a volume of CreateVolumes written to memory.
+type volumeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (v *volumeBuffer) Close() error {
+	v.closed = true
+	return nil
+}
+
+func TestCreateVolumes(t *testing.T) {
+	var entries []entry
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		entries = append(entries, entry{"cache/download/" + name + "/@v/v1.0.0.zip", strings.Repeat(name, 3000)})
+	}
+
+	tests := []struct {
+		name       string
+		volumeSize int64
+		volumes    int
+	}{
+		{name: "single", volumeSize: 1 << 20, volumes: 1},
+		{name: "file per volume", volumeSize: 3500, volumes: len(entries)},
+		{name: "two files per volume", volumeSize: 6800, volumes: len(entries) / 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst := t.TempDir(), t.TempDir()
+			writeFiles(t, src, entries...)
+
+			var volumes []*volumeBuffer
+			n, err := CreateVolumes(src, tt.volumeSize, func(n int) (io.WriteCloser, error) {
+				if n != len(volumes)+1 {
+					t.Errorf("volume %v requested after %v volumes", n, len(volumes))
+				}
+				v := &volumeBuffer{}
+				volumes = append(volumes, v)
+				return v, nil
+			}, Options{Compression: CompressionStore})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != tt.volumes || len(volumes) != tt.volumes {
+				t.Fatalf("created %v volumes (%v requested), want %v", n, len(volumes), tt.volumes)
+			}
+
+			for i, v := range volumes {
+				if !v.closed {
+					t.Errorf("volume %v not closed", i+1)
+				}
+				if int64(v.Len()) > tt.volumeSize {
+					t.Errorf("volume %v has %v bytes, more than %v", i+1, v.Len(), tt.volumeSize)
+				}
+
+				// Every volume is a standalone archive
+				zr, err := zip.NewReader(bytes.NewReader(v.Bytes()), int64(v.Len()))
+				if err != nil {
+					t.Fatalf("volume %v: %v", i+1, err)
+				}
+				if _, err := ExtractReader(zr, dst, ExtractOptions{}); err != nil {
+					t.Fatalf("volume %v: %v", i+1, err)
+				}
+			}
+
+			files := readFiles(t, dst)
+			if len(files) != len(entries) {
+				t.Errorf("extracted %v files of all volumes, want %v", len(files), len(entries))
+			}
+			for _, e := range entries {
+				if files[e.name] != e.body {
+					t.Errorf("content of %v differs", e.name)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateVolumesFileTooLarge(t *testing.T) {
+	src := t.TempDir()
+	writeFiles(t, src, entry{"large.zip", strings.Repeat("x", 5000)})
+
+	_, err := CreateVolumes(src, 4096, func(n int) (io.WriteCloser, error) {
+		return &volumeBuffer{}, nil
+	}, Options{Compression: CompressionStore})
+	if err == nil || !strings.Contains(err.Error(), "doesn't fit into a volume") {
+		t.Fatalf("error = %v, want a file which doesn't fit into a volume", err)
+	}
+}
+
+func TestExtractReaderTraversal(t *testing.T) {
+	for _, name := range []string{"../evil", "a/../../evil", "/evil"} {
+		t.Run(name, func(t *testing.T) {
+			root := t.TempDir()
+			dst := filepath.Join(root, "dst")
+			zr := zipReader(t, entry{"a/ok.txt", "ok"}, entry{name, "evil"})
+
+			if _, err := ExtractReader(zr, dst, ExtractOptions{}); !errors.Is(err, ErrIllegalPath) {
+				t.Fatalf("error = %v, want ErrIllegalPath", err)
+			}
+			if files := readFiles(t, root); len(files) != 0 {
+				t.Fatalf("files written for a rejected archive: %q", files)
+			}
+
+			var skipped []string
+			n, err := ExtractReader(zr, dst, ExtractOptions{OnIllegalPath: func(name string, err error) error {
+				skipped = append(skipped, name)
+				return nil
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != 1 || len(skipped) != 1 || skipped[0] != name {
+				t.Errorf("extracted %v files and skipped %q, want 1 file and [%q]", n, skipped, name)
+			}
+			if files := readFiles(t, root); len(files) != 1 || files["dst/a/ok.txt"] != "ok" {
+				t.Errorf("files = %q, want only dst/a/ok.txt", files)
+			}
+		})
+	}
+}
+
+func TestExtractReaderBackslashTraversal(t *testing.T) {
+	root := t.TempDir()
+	dst := filepath.Join(root, "dst")
+	zr := zipReader(t, entry{`cache\download\a\@v\list`, "v1.0.0\n"}, entry{`..\evil`, "evil"})
+
+	if _, err := ExtractReader(zr, dst, ExtractOptions{}); !errors.Is(err, ErrIllegalPath) {
+		t.Fatalf("error = %v, want ErrIllegalPath", err)
+	}
+	if files := readFiles(t, root); len(files) != 0 {
+		t.Fatalf("files written for a rejected archive: %q", files)
+	}
+}
+
+func TestBackslashRoundTrip(t *testing.T) {
+	entries := []entry{
+		{`cache\download\example.com\!foo\@v\list`, "v1.0.0\n"},
+		{`cache\download\example.com\!foo\@v\v1.0.0.mod`, "module example.com/Foo\n"},
+		{`cache\download\sumdb\sum.golang.org\supported`, ""},
+	}
+
+	// Extracting an archive made on Windows creates the directories, re-creating it writes slashes
+	extracted := t.TempDir()
+	if _, err := ExtractReader(zipReader(t, entries...), extracted, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := Create(extracted, &buf, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "cache/download/example.com/!foo/@v/list,cache/download/example.com/!foo/@v/v1.0.0.mod,cache/download/sumdb/sum.golang.org/supported"
+	if got := strings.Join(entryNames(zr), ","); got != want {
+		t.Fatalf("entries = %v, want %v", got, want)
+	}
+
+	dst := t.TempDir()
+	if _, err := ExtractReader(zr, dst, ExtractOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	files := readFiles(t, dst)
+	for _, e := range entries {
+		if body, ok := files[EntryName(e.name)]; !ok || body != e.body {
+			t.Errorf("%v = %q (found %v), want %q", EntryName(e.name), body, ok, e.body)
+		}
+	}
+}
+
+func TestFileModeRoundTrip(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows has no executable permission")
+	}
+
+	for _, reproducible := range []bool{false, true} {
+		src, dst := t.TempDir(), t.TempDir()
+		writeFiles(t, src, entry{"bin/tool.sh", "#!/bin/sh\n"}, entry{"data.txt", "data"})
+		if err := os.Chmod(filepath.Join(src, "bin", "tool.sh"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(filepath.Join(src, "data.txt"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		if err := Create(src, &buf, Options{Reproducible: reproducible}); err != nil {
+			t.Fatal(err)
+		}
+		zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ExtractReader(zr, dst, ExtractOptions{}); err != nil {
+			t.Fatal(err)
+		}
+
+		for name, want := range map[string]os.FileMode{"bin/tool.sh": 0755, "data.txt": 0644} {
+			fi, err := os.Stat(filepath.Join(dst, filepath.FromSlash(name)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fi.Mode().Perm(); got != want {
+				t.Errorf("reproducible %v: mode of %v = %v, want %v", reproducible, name, got, want)
+			}
+		}
+	}
+}
+
+// TestExtractReaderSharedDirectories extracts many files below deep directories sharing their
+// prefixes in parallel, run it with -race to check the directory creation.
+func TestExtractReaderSharedDirectories(t *testing.T) {
+	var entries []entry
+	for i := 0; i < 8; i++ {
+		prefix := "cache/download/example.com/org/" + strings.Repeat("a/", i) + "mod"
+		for j := 0; j < 16; j++ {
+			name := prefix + "/@v/v1.0." + string(rune('a'+j)) + ".mod"
+			entries = append(entries, entry{name, name})
+		}
+		entries = append(entries, entry{prefix + "@v1.0.0/" + strings.Repeat("pkg/", 8) + "file.go", "package pkg"})
+	}
+
+	for i := 0; i < 4; i++ {
+		dst := t.TempDir()
+		n, err := ExtractReader(zipReader(t, entries...), dst, ExtractOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != len(entries) {
+			t.Fatalf("extracted %v files, want %v", n, len(entries))
+		}
+		files := readFiles(t, dst)
+		for _, e := range entries {
+			if files[e.name] != e.body {
+				t.Fatalf("content of %v = %q, want %q", e.name, files[e.name], e.body)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+)
+
+// AzBlobPublishCmd publishes an archive of modules to an Azure Blob Storage container in the proxy
+// layout. The credentials are resolved by the default credential chain of the Azure SDK (AZURE_*
+// environment variables, workload and managed identity, az login).
+type AzBlobPublishCmd struct {
+	publishCmd
+	Account   string `long:"account" required:"yes" description:"Name of the storage account."`
+	Container string `long:"container" required:"yes" description:"Name of the blob container."`
+	Prefix    string `long:"prefix" description:"Blob name prefix of the proxy folder in the container."`
+	Overwrite bool   `long:"overwrite" description:"Upload all files, including the ones already present in the container."`
+
+	client *azblob.Client
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (a *AzBlobPublishCmd) Execute(args []string) error {
+	log.SetPrefix("Publish-AzBlob: ")
+
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return fmt.Errorf("failed to load azure credentials: %w", err)
+	}
+	a.client, err = azblob.NewClient(fmt.Sprintf("https://%v.blob.core.windows.net/", a.Account), cred, nil)
+	if err != nil {
+		return err
+	}
+
+	return publishObjects(a.publishCmd, a, a.Prefix, a.Overwrite)
+}
+
+func (a *AzBlobPublishCmd) url(prefix string) string {
+	return fmt.Sprintf("https://%v.blob.core.windows.net/%v/%v", a.Account, a.Container, prefix)
+}
+
+func (a *AzBlobPublishCmd) putObject(key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = a.client.UploadFile(context.Background(), a.Container, key, f, nil)
+	return err
+}
+
+// listKeys returns the names of all blobs below prefix, relative to the prefix.
+func (a *AzBlobPublishCmd) listKeys(prefix string) (map[string]struct{}, error) {
+	var opts azblob.ListBlobsFlatOptions
+	if prefix != "" {
+		blobPrefix := prefix + "/"
+		opts.Prefix = &blobPrefix
+	}
+
+	blobs := map[string]struct{}{}
+	pages := a.client.NewListBlobsFlatPager(a.Container, &opts)
+	for pages.More() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range page.Segment.BlobItems {
+			blobs[strings.TrimPrefix(strings.TrimPrefix(*blob.Name, prefix), "/")] = struct{}{}
+		}
+	}
+	return blobs, nil
+}
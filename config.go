@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+)
+
+// defaultConfigFile is the config file loaded from the working directory if --config isn't set.
+const defaultConfigFile = ".gop.toml"
+
+// tomlKeyRegex matches the bare keys of TOML.
+var tomlKeyRegex = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// loadConfig sets the option values of the config file as defaults, which are overridden by the
+// command line. The config file is needed before the command line is parsed, so --config is looked
+// up in args directly.
+func loadConfig(args []string) error {
+	file := os.Getenv("GOP_CONFIG")
+	for i, a := range args {
+		if a == "--" {
+			break
+		}
+		if strings.HasPrefix(a, "--config=") {
+			file = strings.TrimPrefix(a, "--config=")
+		} else if a == "--config" && i+1 < len(args) {
+			file = args[i+1]
+		}
+	}
+	if file == "" {
+		if !fileExists(defaultConfigFile) {
+			return nil
+		}
+		file = defaultConfigFile
+	}
+
+	if err := parseConfigFile(parser, file); err != nil {
+		return fmt.Errorf("failed to read config file %v: %w", file, err)
+	}
+	configOptional(parser.Command)
+	return nil
+}
+
+// parseConfigFile sets the options of p from the TOML config file. The file is translated to the
+// ini format of go-flags, so the options are set exactly like by an ini file.
+func parseConfigFile(p *flags.Parser, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ini, lines, err := tomlToIni(f)
+	if err != nil {
+		return err
+	}
+
+	err = flags.NewIniParser(p).Parse(strings.NewReader(ini))
+	var iniErr *flags.IniError
+	if errors.As(err, &iniErr) {
+		// Report the line of the config file instead of the translated one
+		line := int(iniErr.LineNumber)
+		if line > 0 && line <= len(lines) {
+			line = lines[line-1]
+		}
+		return fmt.Errorf("line %v: %v", line, iniErr.Message)
+	}
+	return err
+}
+
+// tomlToIni translates a TOML config to the ini format of go-flags and returns the line of the
+// config of every ini line. Only the subset of TOML needed for option values is supported: tables
+// ([pack]), bare or quoted keys and strings, integers, booleans and arrays of them as values.
+// The elements of an array are written as repeated key.
+func tomlToIni(r io.Reader) (ini string, lines []int, err error) {
+	var b strings.Builder
+	add := func(line int, format string, v ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", v...)
+		lines = append(lines, line)
+	}
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		if line[0] == '[' {
+			if strings.HasPrefix(line, "[[") {
+				return "", nil, fmt.Errorf("line %v: arrays of tables aren't supported", lineNo)
+			}
+			header, rest, err := cutTOMLValue(line[1:], "]")
+			if err != nil {
+				return "", nil, fmt.Errorf("line %v: %w", lineNo, err)
+			}
+			if !isTOMLComment(rest) {
+				return "", nil, fmt.Errorf("line %v: unexpected %q after table", lineNo, rest)
+			}
+			name, err := tomlKey(header)
+			if err != nil {
+				return "", nil, fmt.Errorf("line %v: %w", lineNo, err)
+			}
+			add(lineNo, "[%v]", name)
+			continue
+		}
+
+		key, value, err := cutTOMLValue(line, "=")
+		if err != nil {
+			return "", nil, fmt.Errorf("line %v: %w", lineNo, err)
+		}
+		name, err := tomlKey(key)
+		if err != nil {
+			return "", nil, fmt.Errorf("line %v: %w", lineNo, err)
+		}
+
+		// Arrays may span several lines until the closing bracket
+		startLine := lineNo
+		value = strings.TrimSpace(value)
+		for strings.HasPrefix(value, "[") && !tomlArrayClosed(value) && scanner.Scan() {
+			lineNo++
+			value += "\n" + scanner.Text()
+		}
+
+		values, err := tomlValues(value)
+		if err != nil {
+			return "", nil, fmt.Errorf("line %v: %w", startLine, err)
+		}
+		for _, v := range values {
+			add(startLine, "%v = %v", name, strconv.Quote(v))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", nil, err
+	}
+	return b.String(), lines, nil
+}
+
+// tomlKey returns the name of a bare or quoted key.
+func tomlKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if tomlKeyRegex.MatchString(key) {
+		return key, nil
+	}
+	if v, rest, err := tomlString(key); err == nil && rest == "" {
+		return v, nil
+	}
+	return "", fmt.Errorf("invalid key %q", key)
+}
+
+// tomlValues returns the value of a string, integer or boolean, or the elements of an array of them.
+func tomlValues(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		v, rest, err := tomlScalar(value)
+		if err != nil {
+			return nil, err
+		}
+		if !isTOMLComment(rest) {
+			return nil, fmt.Errorf("unexpected %q after value", rest)
+		}
+		return []string{v}, nil
+	}
+
+	var values []string
+	rest := value[1:]
+	for {
+		rest = trimTOMLSpace(rest)
+		if strings.HasPrefix(rest, "]") {
+			break
+		}
+		v, r, err := tomlScalar(rest)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+
+		rest = trimTOMLSpace(r)
+		if strings.HasPrefix(rest, ",") {
+			rest = rest[1:]
+		} else if !strings.HasPrefix(rest, "]") {
+			return nil, errors.New("missing , or ] in array")
+		}
+	}
+	if rest = rest[1:]; !isTOMLComment(rest) {
+		return nil, fmt.Errorf("unexpected %q after array", strings.TrimSpace(rest))
+	}
+	return values, nil
+}
+
+// tomlScalar returns the string, integer or boolean at the start of s and the remainder of s.
+func tomlScalar(s string) (value, rest string, err error) {
+	if strings.HasPrefix(s, `"`) || strings.HasPrefix(s, "'") {
+		return tomlString(s)
+	}
+
+	end := strings.IndexAny(s, " \t\n,]#")
+	if end < 0 {
+		end = len(s)
+	}
+	value, rest = s[:end], s[end:]
+	if value == "true" || value == "false" {
+		return value, rest, nil
+	}
+	if _, err := strconv.ParseInt(strings.ReplaceAll(value, "_", ""), 0, 64); err != nil {
+		return "", "", fmt.Errorf("invalid value %q, strings must be quoted", value)
+	}
+	return strings.ReplaceAll(value, "_", ""), rest, nil
+}
+
+// tomlString returns the basic ("...") or literal ('...') string at the start of s and the
+// remainder of s. Multi-line strings aren't supported.
+func tomlString(s string) (value, rest string, err error) {
+	if strings.HasPrefix(s, `"""`) || strings.HasPrefix(s, "'''") {
+		return "", "", errors.New("multi-line strings aren't supported")
+	}
+	if strings.HasPrefix(s, "'") {
+		end := strings.IndexAny(s[1:], "'\n")
+		if end < 0 || s[1+end] != '\'' {
+			return "", "", errors.New("unterminated string")
+		}
+		return s[1 : 1+end], s[2+end:], nil
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '\n':
+			return "", "", errors.New("unterminated string")
+		case '"':
+			// The escapes of TOML are a subset of the ones of Go
+			v, err := strconv.Unquote(s[:i+1])
+			if err != nil {
+				return "", "", fmt.Errorf("invalid string %v", s[:i+1])
+			}
+			return v, s[i+1:], nil
+		}
+	}
+	return "", "", errors.New("unterminated string")
+}
+
+// cutTOMLValue splits s at the first sep outside of a string.
+func cutTOMLValue(s, sep string) (before, after string, err error) {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"' || s[i] == '\'':
+			_, rest, err := tomlString(s[i:])
+			if err != nil {
+				return "", "", err
+			}
+			i = len(s) - len(rest) - 1
+		case strings.HasPrefix(s[i:], sep):
+			return s[:i], s[i+len(sep):], nil
+		}
+	}
+	return "", "", fmt.Errorf("missing %v in %q", sep, s)
+}
+
+// tomlArrayClosed reports whether the array value contains its closing bracket.
+func tomlArrayClosed(value string) bool {
+	depth := 0
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '"', '\'':
+			_, rest, err := tomlString(value[i:])
+			if err != nil {
+				// Reported by tomlValues
+				return true
+			}
+			i = len(value) - len(rest) - 1
+		case '#':
+			// Skip the comment up to the end of the line
+			if end := strings.IndexByte(value[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(value)
+			}
+		case '[':
+			depth++
+		case ']':
+			if depth--; depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// trimTOMLSpace trims the leading whitespace, newlines and comments of s.
+func trimTOMLSpace(s string) string {
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		if !strings.HasPrefix(s, "#") {
+			return s
+		}
+		end := strings.IndexByte(s, '\n')
+		if end < 0 {
+			return ""
+		}
+		s = s[end:]
+	}
+}
+
+// isTOMLComment reports whether s is empty or a comment.
+func isTOMLComment(s string) bool {
+	s = strings.TrimSpace(s)
+	return s == "" || s[0] == '#'
+}
+
+// configOptional makes the required options of c and its subcommands which are set by the config
+// file optional, the parser only accepts required options given on the command line.
+func configOptional(c *flags.Command) {
+	var groupOptional func(g *flags.Group)
+	groupOptional = func(g *flags.Group) {
+		for _, o := range g.Options() {
+			if o.IsSet() {
+				o.Required = false
+			}
+		}
+		for _, sub := range g.Groups() {
+			groupOptional(sub)
+		}
+	}
+
+	groupOptional(c.Group)
+	for _, sub := range c.Commands() {
+		configOptional(sub)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jessevdk/go-flags"
+)
+
+func TestParseConfigFile(t *testing.T) {
+	var opts struct {
+		GoProxy string `long:"goproxy"`
+		Pack    struct {
+			Exclude []string `long:"exclude"`
+			Jobs    int      `long:"jobs" default:"2"`
+			Sum     bool     `long:"sum"`
+			Output  string   `short:"o" long:"out"`
+		} `command:"pack"`
+		Publish struct {
+			Repo string `short:"r" long:"repo" required:"yes"`
+		} `command:"publish-jfrog"`
+	}
+	p := flags.NewParser(&opts, flags.Default&^flags.PrintErrors)
+
+	file := filepath.Join(t.TempDir(), ".gop.toml")
+	config := `# Defaults of the CI
+goproxy = "https://proxy.corp.example.com" # inline comment
+
+[pack]
+exclude = [
+  "golang.org/x/", # not mirrored
+  'github.com/internal/',
+]
+jobs = 1_0
+sum = true
+"out" = "C:\\archives\\deps.zip"
+
+[publish-jfrog]
+repo = 'go-local'
+`
+	if err := os.WriteFile(file, []byte(config), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if err := parseConfigFile(p, file); err != nil {
+		t.Fatal(err)
+	}
+	configOptional(p.Command)
+
+	if _, err := p.ParseArgs([]string{"pack", "--exclude", "example.com/"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts.GoProxy != "https://proxy.corp.example.com" {
+		t.Errorf("goproxy = %q", opts.GoProxy)
+	}
+	// The command line replaces all values of the config file
+	if want := []string{"example.com/"}; !reflect.DeepEqual(opts.Pack.Exclude, want) {
+		t.Errorf("exclude = %q, want %q", opts.Pack.Exclude, want)
+	}
+	if opts.Pack.Jobs != 10 || !opts.Pack.Sum || opts.Pack.Output != `C:\archives\deps.zip` {
+		t.Errorf("pack options = %+v", opts.Pack)
+	}
+	if opts.Publish.Repo != "go-local" {
+		t.Errorf("repo = %q", opts.Publish.Repo)
+	}
+}
+
+func TestParseConfigFileErrors(t *testing.T) {
+	tests := []struct {
+		config string
+		want   string
+	}{
+		{config: "goproxy = \"off\"\n\n[pack]\nunknown = 1\n", want: "line 4: unknown option: unknown"},
+		{config: "goproxy = https://proxy.example.com\n", want: "line 1: invalid value"},
+		{config: "goproxy = \"https://proxy.example.com\n", want: "line 1: unterminated string"},
+		{config: "[pack]\nexclude = [\"a\" \"b\"]\n", want: "line 2: missing , or ]"},
+		{config: "[[pack]]\n", want: "line 1: arrays of tables aren't supported"},
+		{config: "pack.jobs = 4\n", want: "line 1: invalid key"},
+	}
+
+	for _, tt := range tests {
+		var opts struct {
+			GoProxy string `long:"goproxy"`
+			Pack    struct {
+				Exclude []string `long:"exclude"`
+			} `command:"pack"`
+		}
+		p := flags.NewParser(&opts, flags.Default)
+
+		file := filepath.Join(t.TempDir(), ".gop.toml")
+		if err := os.WriteFile(file, []byte(tt.config), 0666); err != nil {
+			t.Fatal(err)
+		}
+		if err := parseConfigFile(p, file); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseConfigFile(%q) error = %v, want %v", tt.config, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/archive"
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// ExtractCmd extracts the files of a single module from an archive.
+type ExtractCmd struct {
+	Output  string `short:"o" long:"out" required:"yes" description:"Output folder for the extracted files."`
+	PosArgs struct {
+		Archive string `positional-arg-name:"ARCHIVE" description:"Path to archive with dependencies."`
+		Module  string `positional-arg-name:"MODULE" description:"Module to extract (github.com/jessevdk/go-flags@v1.4.0), without version all versions of modules matching the path prefix are extracted."`
+	} `positional-args:"yes" required:"2"`
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (e *ExtractCmd) Execute(args []string) error {
+	log.SetPrefix("Extract: ")
+
+	zr, err := zip.OpenReader(e.PosArgs.Archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+
+	dst, err := filepath.Abs(e.Output)
+	if err != nil {
+		return err
+	}
+
+	count, err := archive.ExtractReader(&zr.Reader, dst, archive.ExtractOptions{
+		Include: func(name string) bool {
+			if !e.matches(name) {
+				return false
+			}
+			verboseF("extracting file: %v\n", color.BlueString(name))
+			return true
+		},
+		OnIllegalPath: skipIllegalPath,
+	})
+	if err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return fmt.Errorf("module not found in archive: %v", e.PosArgs.Module)
+	}
+
+	log.Printf("extracted %v files to: %v\n", count, color.GreenString(dst))
+	return nil
+}
+
+// matches reports whether the archive entry belongs to the requested module.
+func (e *ExtractCmd) matches(name string) bool {
+	path, version := packager.ModuleOfCachePath(name)
+	if path == "" {
+		return false
+	}
+
+	query, queryVersion := e.PosArgs.Module, ""
+	if i := strings.LastIndex(query, "@"); i >= 0 {
+		query, queryVersion = query[:i], query[i+1:]
+	}
+
+	if queryVersion != "" {
+		return path == query && (version == queryVersion || version == "")
+	}
+	return path == query || strings.HasPrefix(path, strings.TrimSuffix(query, "/")+"/")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSPublishCmd publishes an archive of modules to a Google Cloud Storage bucket in the proxy layout.
+// The credentials are resolved by Application Default Credentials and the walk and skip logic is
+// shared with the other buckets through objectStore.
+type GCSPublishCmd struct {
+	publishCmd
+	Bucket    string `long:"bucket" required:"yes" description:"Name of the GCS bucket."`
+	Prefix    string `long:"prefix" description:"Object name prefix of the proxy folder in the bucket."`
+	Overwrite bool   `long:"overwrite" description:"Upload all files, including the ones already present in the bucket."`
+
+	bucket *storage.BucketHandle
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (g *GCSPublishCmd) Execute(args []string) error {
+	log.SetPrefix("Publish-GCS: ")
+
+	client, err := storage.NewClient(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to create storage client: %w", err)
+	}
+	defer client.Close()
+	g.bucket = client.Bucket(g.Bucket)
+
+	return publishObjects(g.publishCmd, g, g.Prefix, g.Overwrite)
+}
+
+func (g *GCSPublishCmd) url(prefix string) string {
+	if prefix == "" {
+		return "gs://" + g.Bucket
+	}
+	return "gs://" + g.Bucket + "/" + prefix
+}
+
+func (g *GCSPublishCmd) putObject(key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := g.bucket.Object(key).NewWriter(context.Background())
+	if _, err := io.Copy(w, f); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// listKeys returns the names of all objects below prefix, relative to the prefix.
+func (g *GCSPublishCmd) listKeys(prefix string) (map[string]struct{}, error) {
+	query := &storage.Query{}
+	if prefix != "" {
+		query.Prefix = prefix + "/"
+	}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]struct{}{}
+	it := g.bucket.Objects(context.Background(), query)
+	for {
+		attrs, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		keys[strings.TrimPrefix(strings.TrimPrefix(attrs.Name, prefix), "/")] = struct{}{}
+	}
+	return keys, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// uploadModule uploads the .info, .mod and .zip file of the module version in dir from the download
+// cache of workDir with the go API of Artifactory, like jfrog rt gp does. The module zip is taken
+// from the archive instead of being created from the extracted sources.
+func (j *JFrogPublishCmd) uploadModule(workDir, dir, modName, version string) error {
+	relMod, err := filepath.Rel(workDir, dir)
+	if err != nil {
+		return err
+	}
+	escVersion := filepath.Base(dir)[strings.LastIndex(filepath.Base(dir), "@")+1:]
+	dlDir := filepath.Join(workDir, "cache", "download", strings.TrimSuffix(relMod, "@"+escVersion), "@v")
+	if !fileExists(filepath.Join(dlDir, escVersion+".zip")) {
+		return fmt.Errorf("module zip not found in archive: %v", filepath.Join(dlDir, escVersion+".zip"))
+	}
+
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		file := filepath.Join(dlDir, escVersion+ext)
+		if ext == ".info" && !fileExists(file) {
+			continue
+		}
+		// The go API expects the case-encoded module path and version like a GOPROXY
+		url := fmt.Sprintf("%v/api/go/%v/%v/@v/%v%v", j.URL, j.Repo, packager.EscapePath(modName), packager.EscapePath(version), ext)
+		if err := j.put(url, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// put uploads file to url.
+func (j *JFrogPublishCmd) put(url, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequest(http.MethodPut, url, f)
+	if err != nil {
+		return err
+	}
+	if fi, err := f.Stat(); err == nil {
+		req.ContentLength = fi.Size()
+	}
+	req.Header.Set("Authorization", "Bearer "+j.Token)
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to upload %v: %v %v", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// get returns the response body of the REST API path of Artifactory.
+func (j *JFrogPublishCmd) get(path string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, j.URL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+j.Token)
+
+	resp, err := j.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestJFrogUploadModuleEscapesPath(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		paths = append(paths, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	workDir := t.TempDir()
+	dir := filepath.Join(workDir, "github.com", "!foo", "!bar", "v2@v2.0.0-!r!c1")
+	dlDir := filepath.Join(workDir, "cache", "download", "github.com", "!foo", "!bar", "v2", "@v")
+	for _, d := range []string{dir, dlDir} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, ext := range []string{".info", ".mod", ".zip"} {
+		if err := os.WriteFile(filepath.Join(dlDir, "v2.0.0-!r!c1"+ext), []byte("x"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	j := &JFrogPublishCmd{Repo: "go-local", URL: srv.URL, client: srv.Client()}
+	if err := j.uploadModule(workDir, dir, "github.com/Foo/Bar/v2", "v2.0.0-RC1"); err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(paths)
+	want := []string{
+		"/api/go/go-local/github.com/!foo/!bar/v2/@v/v2.0.0-!r!c1.info",
+		"/api/go/go-local/github.com/!foo/!bar/v2/@v/v2.0.0-!r!c1.mod",
+		"/api/go/go-local/github.com/!foo/!bar/v2/@v/v2.0.0-!r!c1.zip",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("uploaded %v, want %v", paths, want)
+	}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Errorf("uploaded %v, want %v", paths[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/go-sharp/go-offline-packager/archive"
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// ListCmd prints the modules contained in an archive.
+type ListCmd struct {
+	JSON    bool `long:"json" description:"Print the modules as JSON array of {path, version} objects."`
+	PosArgs struct {
+		Archive string `positional-arg-name:"ARCHIVE" description:"Path to archive with dependencies."`
+	} `positional-args:"yes" required:"1"`
+}
+
+type listedModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (l *ListCmd) Execute(args []string) error {
+	zr, err := zip.OpenReader(l.PosArgs.Archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+
+	mods := listArchiveModules(&zr.Reader)
+	if l.JSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(mods)
+	}
+
+	for _, m := range mods {
+		fmt.Println(m.Path + "@" + m.Version)
+	}
+	return nil
+}
+
+// listArchiveModules returns the distinct module versions of the .info files in the download
+// cache of the archive, sorted by path and version.
+func listArchiveModules(zr *zip.Reader) []listedModule {
+	seen := map[string]struct{}{}
+	mods := []listedModule{}
+	for _, f := range zr.File {
+		name := archive.EntryName(f.Name)
+		if !strings.HasPrefix(name, "cache/download/") || path.Ext(name) != ".info" {
+			continue
+		}
+
+		modPath, version := packager.ModuleOfCachePath(name)
+		if modPath == "" || version == "" {
+			continue
+		}
+		if _, ok := seen[modPath+"@"+version]; ok {
+			continue
+		}
+		seen[modPath+"@"+version] = struct{}{}
+		mods = append(mods, listedModule{Path: modPath, Version: version})
+	}
+
+	sort.Slice(mods, func(i, j int) bool {
+		if mods[i].Path != mods[j].Path {
+			return mods[i].Path < mods[j].Path
+		}
+		return mods[i].Version < mods[j].Version
+	})
+	return mods
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sharp/color"
+)
+
+var (
+	ansiRegex      = regexp.MustCompile(`\x1b\[[0-9;]*[A-Za-z]`)
+	logModuleRegex = regexp.MustCompile(`[a-zA-Z0-9][\w.~-]*\.[\w.~/-]+@v[0-9][\w.+-]*`)
+	// errorLineRegex matches the prefixes of error messages: error:, failed (ex. failed: or failed to),
+	// optionally after the progress of a module ([3/10, eta 5s]), and the summary of the failed modules.
+	errorLineRegex = regexp.MustCompile(`^(\[[^\]]*\] )?(error:|failed\b)|^\d+ modules failed:`)
+)
+
+// jsonLogger is the output of the logger with --log-format json, nil for the text format.
+var jsonLogger *jsonLogWriter
+
+// setupLogging switches the logger to the format of --log-format, with --quiet only errors are logged.
+func setupLogging() {
+	var out io.Writer = os.Stderr
+	if commonOpts.LogFormat == "json" {
+		color.NoColor = true
+		jsonLogger = &jsonLogWriter{w: os.Stderr}
+		out = jsonLogger
+	}
+	if commonOpts.Quiet {
+		out = &quietLogWriter{w: out}
+	}
+	log.SetOutput(out)
+}
+
+// logLevel returns the level of a log line without colors: lines starting with error: or failed are
+// errors, warning: are warnings and everything else is info.
+func logLevel(line string) string {
+	switch {
+	case errorLineRegex.MatchString(line):
+		return "error"
+	case strings.HasPrefix(line, "warning:"):
+		return "warning"
+	}
+	return "info"
+}
+
+// quietLogWriter only writes the messages logged with the log package which are errors. Indented
+// messages (ex. the modules of a failure summary) belong to the previous message.
+type quietLogWriter struct {
+	// mu guards isError, the progress is logged from several goroutines.
+	mu      sync.Mutex
+	w       io.Writer
+	isError bool
+}
+
+func (q *quietLogWriter) Write(p []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	msg := ansiRegex.ReplaceAllString(strings.TrimPrefix(string(p), log.Prefix()), "")
+	if i := strings.Index(msg, "\n"); i >= 0 {
+		msg = msg[:i]
+	}
+	if !strings.HasPrefix(msg, "\t") && !strings.HasPrefix(msg, " ") {
+		q.isError = logLevel(strings.TrimSpace(msg)) == "error"
+	}
+	if !q.isError {
+		return len(p), nil
+	}
+	if _, err := q.w.Write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// jsonLogEntry is a line of the JSON log.
+type jsonLogEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Command string `json:"command,omitempty"`
+	Module  string `json:"module,omitempty"`
+	Message string `json:"message"`
+}
+
+// jsonLogWriter writes every line logged with the log package as JSON object. The level is derived
+// from the message (see logLevel) and the first module@version
+// in the message is set as module. Color codes are removed.
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	if err := j.writeLines("", strings.TrimPrefix(string(p), log.Prefix())); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writeLines writes every line of msg as JSON object. If level is empty, it is derived from the
+// message and applies to the following lines as well.
+func (j *jsonLogWriter) writeLines(level, msg string) error {
+	for _, line := range strings.Split(msg, "\n") {
+		line = strings.TrimSpace(ansiRegex.ReplaceAllString(line, ""))
+		lineLevel := logLevel(line)
+		line = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(line, "error:"), "warning:"))
+		if level == "" {
+			level = lineLevel
+		}
+		if line == "" {
+			continue
+		}
+		if err := j.writeEntry(level, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeEntry writes msg as JSON object with the given level.
+func (j *jsonLogWriter) writeEntry(level, msg string) error {
+	data, err := json.Marshal(jsonLogEntry{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Level:   level,
+		Command: strings.TrimSuffix(strings.TrimSpace(log.Prefix()), ":"),
+		Module:  logModuleRegex.FindString(msg),
+		Message: msg,
+	})
+	if err != nil {
+		return err
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(append(data, '\n'))
+	return err
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-sharp/color"
+)
+
+func TestLogLevel(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "error: missing go binary", want: "error"},
+		{line: "failed: --cache-clean requires --cache-dir", want: "error"},
+		{line: "failed to write manifest: permission denied", want: "error"},
+		{line: "[3/10, eta 5s] failed to resolve module: example.com/a@v1.0.0", want: "error"},
+		{line: "2 modules failed:", want: "error"},
+		{line: "warning: example.com/a@v1.0.0 failed with a network error, retry 1/3 in 1s", want: "warning"},
+		{line: "[3/10, eta 5s] added module: example.com/failed@v1.0.0", want: "info"},
+		{line: "skipping previously failed module example.com/a@v1.0.0", want: "info"},
+		{line: "failedover proxy", want: "info"},
+		{line: "archive created: gop_dependencies.zip", want: "info"},
+	}
+
+	for _, tt := range tests {
+		if got := logLevel(tt.line); got != tt.want {
+			t.Errorf("logLevel(%q) = %v, want %v", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestQuietLogWriter(t *testing.T) {
+	var buf bytes.Buffer
+	q := &quietLogWriter{w: &buf}
+	for _, line := range []string{
+		"prepare dependencies\n",
+		color.RedString("error:") + " failed to download\n",
+		"\texample.com/a@v1.0.0\n",
+		"archive created: gop_dependencies.zip\n",
+		"\tnot an error\n",
+		"2 modules failed:\n",
+		"\texample.com/b@v1.0.0\n",
+	} {
+		if _, err := q.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := ansiRegex.ReplaceAllString(buf.String(), "")
+	want := "error: failed to download\n\texample.com/a@v1.0.0\n2 modules failed:\n\texample.com/b@v1.0.0\n"
+	if out != want {
+		t.Errorf("quiet output = %q, want %q", out, want)
+	}
+}
+
+// TestQuietLogWriterConcurrent writes from several goroutines like the progress display, run it
+// with -race.
+func TestQuietLogWriterConcurrent(t *testing.T) {
+	var buf bytes.Buffer
+	q := &quietLogWriter{w: &buf}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				line := "[1/10] added module: example.com/a@v1.0.0\n"
+				if (i+j)%2 == 0 {
+					line = "[1/10] failed to resolve module: example.com/a@v1.0.0\n"
+				}
+				if _, err := q.Write([]byte(line)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 400 {
+		t.Errorf("wrote %v lines, want the 400 errors", len(lines))
+	}
+	for _, l := range lines {
+		if !strings.Contains(l, "failed") {
+			t.Fatalf("wrote non error line %q", l)
+		}
+	}
+}
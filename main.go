@@ -1,36 +1,42 @@
 package main
 
 import (
-	"archive/zip"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/archive"
+	"github.com/go-sharp/go-offline-packager/packager"
 	"github.com/jessevdk/go-flags"
 )
 
-const version = "v0.1.4"
+const version = packager.Version
 
 var commonOpts options
+
 var parser = flags.NewParser(&commonOpts, flags.HelpFlag|flags.PassDoubleDash)
 
 var errorRedPrefix = color.RedString("error:")
 
-const gomodTemp = `
-module go-offline-packager
-
-go 1.13
-`
-
 type options struct {
-	GoBinPath string `long:"go-bin" env:"GOP_GO_BIN" description:"Set full path to go binary"`
-	Verbose   bool   `short:"v" long:"verbose" description:"Verbose output"`
+	GoBinPath string    `long:"go-bin" env:"GOP_GO_BIN" description:"Set full path to go binary"`
+	Verbose   bool      `short:"v" long:"verbose" description:"Verbose output"`
+	Quiet     bool      `short:"q" long:"quiet" description:"Only log errors"`
+	GoEnvFile goEnvFile `long:"go-env-file" env:"GOP_GO_ENV_FILE" description:"File with KEY=VALUE lines which are set as environment of the go commands"`
+	CACert    string    `long:"ca-cert" env:"GOP_CA_CERT" description:"CA certificate bundle (PEM) trusted by the go commands, sets SSL_CERT_FILE"`
+	GoFlags   string    `long:"goflags" env:"GOP_GOFLAGS" description:"GOFLAGS of the go commands (ex. -insecure), overrides the environment"`
+	GoProxy   string    `long:"goproxy" env:"GOP_GOPROXY" description:"GOPROXY of the go commands, overrides the environment (a list separated by , or | falls back like go)"`
+	TempDir   string    `long:"tmpdir" env:"GOP_TMPDIR" description:"Directory for the temporary working directories (module cache, extracted archives), defaults to the system temp directory"`
+	LogFormat string    `long:"log-format" env:"GOP_LOG_FORMAT" choice:"text" choice:"json" default:"text" description:"Format of the log output, json writes a JSON object (time, level, command, module, message) per line"`
+	Config    string    `long:"config" env:"GOP_CONFIG" no-ini:"true" description:"Config file with default option values, .gop.toml in the working directory is used if present"`
 }
 
 func init() {
@@ -44,6 +50,36 @@ func init() {
 	_, _ = parser.AddCommand("publish-jfrog", "Publish archive to jfrog artifactory (requires installed and configured jfrog-cli).",
 		"Publish archive to jfrog artifactory (requires installed and configured jfrog-cli).", &JFrogPublishCmd{})
 
+	_, _ = parser.AddCommand("publish-nexus", "Publish archive to a Sonatype Nexus raw repository.",
+		"Publish archive to a Sonatype Nexus raw hosted repository in the proxy layout.", &NexusPublishCmd{})
+
+	_, _ = parser.AddCommand("publish-s3", "Publish archive to an S3 bucket.",
+		"Publish archive to an S3 bucket in the proxy layout, the credentials are read from the AWS configuration.", &S3PublishCmd{})
+
+	_, _ = parser.AddCommand("publish-azblob", "Publish archive to an Azure Blob Storage container.",
+		"Publish archive to an Azure Blob Storage container in the proxy layout, the credentials are read from the AZURE_* environment variables, a managed identity or az login.", &AzBlobPublishCmd{})
+
+	_, _ = parser.AddCommand("publish-gcs", "Publish archive to a Google Cloud Storage bucket.",
+		"Publish archive to a Google Cloud Storage bucket in the proxy layout, the credentials are read from Application Default Credentials.", &GCSPublishCmd{})
+
+	_, _ = parser.AddCommand("extract", "Extract a single module from an archive.",
+		"Extract a single module from an archive without unpacking the whole archive.", &ExtractCmd{})
+
+	_, _ = parser.AddCommand("list", "List the modules of an archive.",
+		"List the modules (module@version) of an archive without extracting it.", &ListCmd{})
+
+	_, _ = parser.AddCommand("repack", "Re-create an archive as normalized archive.",
+		"Re-create an archive as normalized archive with sorted entries and fixed timestamps, without downloading.", &RepackCmd{})
+
+	_, _ = parser.AddCommand("serve", "Serve an archive as module proxy over HTTP.",
+		"Serve the modules of an archive with the GOPROXY protocol over HTTP, without extracting the archive.", &ServeCmd{})
+
+	_, _ = parser.AddCommand("validate", "Validate that a published proxy folder can be consumed by go.",
+		"Validate that a published proxy folder can be consumed by go.", &ValidateCmd{})
+
+	_, _ = parser.AddCommand("verify", "Verify the module hashes of an archive.",
+		"Verify the module hashes of an archive against its .ziphash and list files and optionally a go.sum file.", &VerifyCmd{})
+
 	_, _ = parser.AddCommand("version", "Show version.", "Show version.", &versionCmd{})
 
 	if p, err := exec.LookPath("go"); err == nil {
@@ -55,193 +91,197 @@ func init() {
 }
 
 func main() {
+	if err := loadConfig(os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, color.RedString("%s", err))
+		os.Exit(1)
+	}
+
+	parser.CommandHandler = func(command flags.Commander, args []string) error {
+		if commonOpts.Quiet && commonOpts.Verbose {
+			return errors.New("--quiet can't be used with --verbose")
+		}
+		setupLogging()
+		if commonOpts.Verbose {
+			packager.Verbosef = verboseF
+		}
+		if command == nil {
+			return nil
+		}
+		return command.Execute(args)
+	}
+
 	if _, err := parser.Parse(); err != nil {
 		if t, ok := err.(*flags.Error); ok && t.Type == flags.ErrHelp {
 			parser.WriteHelp(os.Stdout)
 			os.Exit(0)
 		}
-		color.Red("%s", err)
+		if jsonLogger != nil {
+			_ = jsonLogger.writeEntry("error", err.Error())
+			os.Exit(1)
+		}
+		fmt.Fprintln(os.Stderr, color.RedString("%s", err))
 		os.Exit(1)
 	}
 }
 
-func createTempWorkDir() (wd string, cleanFn func()) {
-	dir, err := os.MkdirTemp(os.TempDir(), "gop_")
-	if err != nil {
-		log.Fatalln("failed to create temporary working directory: ", color.RedString(err.Error()))
+func verboseF(format string, v ...interface{}) {
+	if !commonOpts.Verbose {
+		return
 	}
-
-	return dir, func() { removeContent(dir) }
+	if jsonLogger != nil {
+		_ = jsonLogger.writeLines("debug", fmt.Sprintf(format, v...))
+		return
+	}
+	log.Printf(format, v...)
 }
 
-func removeContent(dir string) {
-	defer func() {
-		if err := os.Remove(dir); err != nil {
-			verboseF("can't remove directory: %v\n", err)
+func checkGo() {
+	if f, err := os.Stat(commonOpts.GoBinPath); err != nil || f.IsDir() {
+		log.Fatalln(errorRedPrefix, "missing go binary, install go or specify path to go binary")
+	}
+
+	if commonOpts.CACert != "" {
+		p, err := filepath.Abs(commonOpts.CACert)
+		if err != nil || !fileExists(p) {
+			log.Fatalln(errorRedPrefix, "CA certificate file not found:", commonOpts.CACert)
 		}
-	}()
+		commonOpts.CACert = p
+	}
+}
 
-	f, err := os.Open(dir)
+func createTempWorkDir() (wd string, cleanFn func()) {
+	wd, cleanFn, err := packager.CreateTempWorkDir(commonOpts.TempDir)
 	if err != nil {
-		verboseF("can't remove directory %v: %v\n", dir, color.YellowString(err.Error()))
-		return
+		log.Fatalln(errorRedPrefix, err)
 	}
-	defer f.Close()
+	return wd, cleanFn
+}
 
-	fs, err := f.Readdirnames(0)
-	if err != nil {
-		verboseF("can't read directory %v: %v\n", dir, color.YellowString(err.Error()))
-		return
+// goOptions returns the go binary and the environment of the go commands set by the common options.
+func goOptions() packager.GoOptions {
+	env := append([]string{}, commonOpts.GoEnvFile.env...)
+	if commonOpts.CACert != "" {
+		// Used by go on Linux and BSD to replace the system root certificates
+		env = append(env, "SSL_CERT_FILE="+commonOpts.CACert)
 	}
 
-	for _, fi := range fs {
-		fpath := filepath.Join(dir, fi)
-		fstat, err := os.Stat(fpath)
-		if err != nil {
-			verboseF("can't read file stat %v: %v\n", dir, color.YellowString(err.Error()))
-			continue
-		}
+	// Set last, so the flags take precedence over the environment and the go env file
+	var flagEnv []string
+	if commonOpts.GoFlags != "" {
+		flagEnv = append(flagEnv, "GOFLAGS="+commonOpts.GoFlags)
+	}
+	if commonOpts.GoProxy != "" {
+		flagEnv = append(flagEnv, "GOPROXY="+commonOpts.GoProxy)
+	}
+	env = append(env, flagEnv...)
 
-		// Handle directory
-		if fstat.IsDir() {
-			_ = os.Chmod(fpath, 0777)
-			removeContent(fpath)
-			continue
+	logGoEnvOnce.Do(func() {
+		for _, e := range append(commonOpts.GoEnvFile.env, flagEnv...) {
+			verboseF("go env override: %v\n", color.BlueString(packager.RedactURLs(e)))
 		}
+	})
+	return packager.GoOptions{Bin: commonOpts.GoBinPath, Env: env}
+}
 
-		_ = os.Chmod(fpath, 0666)
-		if err := os.Remove(fpath); err != nil {
-			verboseF("can't remove directory: %v\n", err)
-		}
-	}
+var logGoEnvOnce sync.Once
+
+func getGoCommand(workDir, modCache string, args ...string) *exec.Cmd {
+	return goOptions().Command(workDir, modCache, args...)
 }
 
-func verboseF(format string, v ...interface{}) {
-	if commonOpts.Verbose {
-		log.Printf(format, v...)
-	}
+func fileExists(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && !fi.IsDir()
 }
 
-func checkGo() {
-	if f, err := os.Stat(commonOpts.GoBinPath); err != nil || f.IsDir() {
-		log.Fatalln(errorRedPrefix, "missing go binary, install go or specify path to go binary")
+func folderExists(name string) bool {
+	if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+		return false
 	}
+
+	return true
 }
 
+// extractZipArchive extracts the archive src into dst, all volumes are extracted for an
+// archive split with --split-size.
 func extractZipArchive(src, dst string) error {
 	verboseF("extracting to: %v\n", color.BlueString(dst))
-	if _, err := os.Stat(dst); err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			return err
+	volumes := packager.ArchiveVolumes(src)
+	for _, v := range volumes {
+		if len(volumes) > 1 {
+			verboseF("extracting volume: %v\n", color.BlueString(v))
 		}
-
-		if err := os.MkdirAll(dst, 0777); err != nil {
+		if err := archive.Extract(v, dst, archive.ExtractOptions{OnIllegalPath: skipIllegalPath}); err != nil {
 			return err
 		}
 	}
+	return nil
+}
 
-	zipReader, err := zip.OpenReader(src)
-	if err != nil {
-		return err
-	}
-	defer zipReader.Close()
-
-	for _, f := range zipReader.File {
-		dFName := filepath.FromSlash(filepath.Join(dst, f.Name))
-		// We ignore the error here because we get one as soon we open the file
-		_ = os.MkdirAll(filepath.Dir(dFName), 0777)
-		extractToFile(f, dFName)
-		os.Chtimes(dFName, f.Modified, f.Modified)
-	}
+// skipIllegalPath logs and skips archive entries which would be extracted outside of the destination folder.
+func skipIllegalPath(name string, err error) error {
+	log.Println(errorRedPrefix, color.RedString("skipping entry outside of the destination folder: %v", name))
 	return nil
 }
 
-func extractToFile(f *zip.File, dst string) {
-	destF, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Println(errorRedPrefix, "failed to extract file", f.Name, ":", err)
-		return
-	}
-	defer destF.Close()
+var envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
 
-	srcF, err := f.Open()
-	if err != nil {
-		log.Println(errorRedPrefix, "failed to extract file", f.Name, ":", err)
-		return
-	}
-	defer srcF.Close()
-
-	if _, err := io.Copy(destF, srcF); err != nil {
-		log.Println(errorRedPrefix, "failed to extract file", f.Name, ":", err)
-		return
-	}
+// goEnvFile holds the environment variables read from a KEY=VALUE file.
+type goEnvFile struct {
+	file string
+	env  []string
 }
 
-func createZipArchive(dir, dst string) error {
-	fw, err := os.OpenFile(dst, os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0666)
+// UnmarshalFlag implements the flags.Unmarshaler interface.
+func (g *goEnvFile) UnmarshalFlag(value string) error {
+	data, err := os.ReadFile(value)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed to read go env file: %w", err)
 	}
-	defer fw.Close()
 
-	zw := zip.NewWriter(fw)
-	defer zw.Close()
+	g.file, g.env = value, nil
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
 
-	done := make(chan error)
-	work := make(chan string)
-	go func() {
-		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-			if info.IsDir() {
-				return nil
-			}
+		kv := strings.SplitN(line, "=", 2)
+		key := strings.TrimSpace(kv[0])
+		if len(kv) != 2 || !envKeyRegex.MatchString(key) {
+			return fmt.Errorf("invalid line %v in go env file %v: %q", i+1, value, line)
+		}
 
-			work <- path
-			return nil
-		})
-		close(work)
-		done <- err
-	}()
-
-	for f := range work {
-		if err := addFileToArchive(f, dir, zw); err != nil {
-			log.Printf("%v failed to add to archive: %v\n", errorRedPrefix, err)
+		val := strings.TrimSpace(kv[1])
+		if len(val) >= 2 && (val[0] == '"' || val[0] == '\'') && val[len(val)-1] == val[0] {
+			val = val[1 : len(val)-1]
 		}
+		g.env = append(g.env, key+"="+val)
 	}
-
-	return <-done
+	return nil
 }
 
-func addFileToArchive(file, dir string, zw *zip.Writer) error {
-	reader, err := os.Open(file)
-	if err != nil {
-		return err
-	}
-	defer reader.Close()
-
-	fiStat, err := os.Stat(file)
-	if err != nil {
-		return err
-	}
-
-	name := strings.TrimLeft(strings.TrimPrefix(file, dir), string(filepath.Separator))
-
-	fh, err := zip.FileInfoHeader(fiStat)
-	if err != nil {
-		return err
-	}
-	fh.Name = filepath.ToSlash(name)
+func (g goEnvFile) String() string {
+	return g.file
+}
 
-	writer, err := zw.CreateHeader(fh)
-	if err != nil {
-		return err
-	}
+// fileMode is a file permission which is specified as octal number on the command line.
+type fileMode os.FileMode
 
-	if _, err := io.Copy(writer, reader); err != nil {
-		return err
+// UnmarshalFlag implements the flags.Unmarshaler interface.
+func (m *fileMode) UnmarshalFlag(value string) error {
+	n, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || n > 0777 {
+		return fmt.Errorf("invalid permissions: %v", value)
 	}
+	*m = fileMode(n)
 	return nil
 }
 
+func (m fileMode) String() string {
+	return fmt.Sprintf("%#o", uint32(m))
+}
+
 type versionCmd struct{}
 
 // Execute will be called for the last active (sub)command. The
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+func TestGoCommandCACert(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a shell script as go binary")
+	}
+	defer func(opts options) { commonOpts = opts }(commonOpts)
+
+	dir := t.TempDir()
+	caCert := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caCert, []byte("-----BEGIN CERTIFICATE-----\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	// The go binary prints the environment it's started with
+	goBin := filepath.Join(dir, "go")
+	if err := os.WriteFile(goBin, []byte("#!/bin/sh\necho \"SSL_CERT_FILE=$SSL_CERT_FILE\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("SSL_CERT_FILE", "/etc/ssl/system.pem")
+	defer os.Unsetenv("SSL_CERT_FILE")
+	commonOpts.GoBinPath = goBin
+	commonOpts.CACert = caCert
+	checkGo()
+
+	output, err := packager.RunGoCommand(getGoCommand(dir, dir, "env"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.TrimSpace(string(output)), "SSL_CERT_FILE="+caCert; got != want {
+		t.Errorf("go command started with %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// NexusPublishCmd publishes an archive of modules to a raw hosted repository of a Sonatype Nexus
+// server in the proxy layout. Nexus 3 only has go proxy and group repositories, which can't be
+// uploaded to, so a raw repository serves the files like publish-folder and go uses its url as GOPROXY.
+type NexusPublishCmd struct {
+	publishCmd
+	URL      string `long:"url" required:"yes" description:"Base url of the Nexus server ex. https://nexus.example.com."`
+	Repo     string `short:"r" long:"repo" required:"yes" description:"Nexus raw hosted repository name ex. go-raw."`
+	User     string `long:"user" env:"GOP_NEXUS_USER" description:"User to authenticate with."`
+	Password string `long:"password" env:"GOP_NEXUS_PASS" description:"Password to authenticate with."`
+
+	// repoURL is the url of the repository.
+	repoURL string
+	client  *http.Client
+	// skipped is the number of files already present in the repository.
+	skipped int64
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (n *NexusPublishCmd) Execute(args []string) error {
+	log.SetPrefix("Publish-Nexus: ")
+
+	workDir, cleanFn := createTempWorkDir()
+	defer cleanFn()
+
+	log.Println("extracting archive")
+	if err := n.extractArchive(workDir); err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	n.repoURL = fmt.Sprintf("%v/repository/%v", strings.TrimSuffix(n.URL, "/"), n.Repo)
+	n.client = &http.Client{Timeout: 5 * time.Minute}
+	log.Println("repository:", color.BlueString(n.repoURL))
+
+	log.Println("publishing modules")
+	// Files are uploaded one after another to keep the load on the server low
+	if err := n.publishModules(filepath.Join(workDir, "cache", "download"), 1, n); err != nil {
+		return err
+	}
+
+	log.Printf("modules successfully uploaded, skipped %v existing files\n", atomic.LoadInt64(&n.skipped))
+	return nil
+}
+
+// Select selects the module directories and the checksum database files like publish-folder.
+func (n *NexusPublishCmd) Select(relPath string, info os.FileInfo) (bool, error) {
+	if strings.HasPrefix(relPath, "sumdb/") {
+		return !info.IsDir(), nil
+	}
+	return info.IsDir() && strings.HasSuffix(relPath, "@v"), nil
+}
+
+// PublishModule uploads the .info, .mod and .zip files of a module directory and then its list file,
+// or a single checksum database file. Files already present in the repository are skipped.
+func (n *NexusPublishCmd) PublishModule(dir, relPath string) error {
+	if strings.HasPrefix(relPath, "sumdb/") {
+		return n.publishFile(dir, relPath)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		ext := filepath.Ext(f.Name())
+		if f.IsDir() || (ext != ".info" && ext != ".mod" && ext != ".zip") {
+			continue
+		}
+		if err := n.publishFile(filepath.Join(dir, f.Name()), relPath+"/"+f.Name()); err != nil {
+			return err
+		}
+	}
+	return n.publishList(dir, relPath)
+}
+
+// publishFile uploads the file, a file already present in the repository is skipped with a warning.
+func (n *NexusPublishCmd) publishFile(file, relPath string) error {
+	verboseF("publishing file %v\n", color.BlueString(relPath))
+	exists, err := n.upload(n.client, n.repoURL+"/"+relPath, file)
+	if err != nil {
+		return err
+	} else if exists {
+		atomic.AddInt64(&n.skipped, 1)
+		log.Println(color.YellowString("warning:"), "file already exists, skipping:", relPath)
+	}
+	return nil
+}
+
+// publishList uploads the list file of the module directory dir with the versions of the repository
+// and of the .mod files in dir. A raw repository doesn't create the list files itself, so the list
+// file is replaced, which requires a repository allowing redeploys.
+func (n *NexusPublishCmd) publishList(dir, relPath string) error {
+	listURL := n.repoURL + "/" + relPath + "/list"
+	existing, err := n.download(listURL)
+	if err != nil {
+		return err
+	}
+
+	versions := map[string]struct{}{}
+	for _, v := range strings.Fields(string(existing)) {
+		versions[v] = struct{}{}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.mod"))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		versions[packager.UnescapePath(strings.TrimSuffix(filepath.Base(f), ".mod"))] = struct{}{}
+	}
+
+	list := make([]string, 0, len(versions))
+	for v := range versions {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	listFile := filepath.Join(dir, "list")
+	if err := os.WriteFile(listFile, []byte(strings.Join(list, "\n")+"\n"), 0664); err != nil {
+		return err
+	}
+
+	verboseF("publishing file %v\n", color.BlueString(relPath+"/list"))
+	exists, err := n.upload(n.client, listURL, listFile)
+	if err != nil {
+		return err
+	} else if exists {
+		return fmt.Errorf("failed to update %v: the repository doesn't allow redeploys", listURL)
+	}
+	return nil
+}
+
+// download returns the content of the file at url or nil if it doesn't exist.
+func (n *NexusPublishCmd) download(url string) ([]byte, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if n.User != "" {
+		req.SetBasicAuth(n.User, n.Password)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, fmt.Errorf("failed to download %v: %v", url, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// upload puts file to url. It reports true if the file already exists in the repository.
+func (n *NexusPublishCmd) upload(client *http.Client, url, file string) (exists bool, err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	req, err := http.NewRequest(http.MethodPut, url, f)
+	if err != nil {
+		return false, err
+	}
+	if fi, err := f.Stat(); err == nil {
+		req.ContentLength = fi.Size()
+	}
+	if n.User != "" {
+		req.SetBasicAuth(n.User, n.Password)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusConflict:
+		return true, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return false, fmt.Errorf("failed to upload %v: %v", url, resp.Status)
+	}
+	return false, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// rawRepo is a fake Nexus raw repository, which rejects replacing files other than list files.
+type rawRepo struct {
+	mu    sync.Mutex
+	files map[string]string
+	puts  []string
+}
+
+func (r *rawRepo) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	name := strings.TrimPrefix(req.URL.Path, "/repository/go-raw/")
+	switch req.Method {
+	case http.MethodGet:
+		data, ok := r.files[name]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		_, _ = io.WriteString(w, data)
+	case http.MethodPut:
+		if _, ok := r.files[name]; ok && !strings.HasSuffix(name, "/list") {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		data, _ := io.ReadAll(req.Body)
+		r.files[name] = string(data)
+		r.puts = append(r.puts, name)
+	}
+}
+
+func TestNexusPublishRawRepository(t *testing.T) {
+	src := t.TempDir() + "/gop_dependencies.zip"
+	writeTestArchive(t, src,
+		"cache/download/example.com/a/@v/list", "v1.0.0-RC1\n",
+		"cache/download/example.com/a/@v/v1.0.0-!r!c1.info", `{"Version":"v1.0.0-RC1"}`,
+		"cache/download/example.com/a/@v/v1.0.0-!r!c1.mod", "module example.com/a\n",
+		"cache/download/example.com/a/@v/v1.0.0-!r!c1.zip", moduleZip(t, "example.com/a", "v1.0.0-RC1"),
+		"cache/download/example.com/a/@v/v1.0.0-!r!c1.lock", "",
+		"cache/download/sumdb/sum.golang.org/supported", "",
+	)
+
+	repo := &rawRepo{files: map[string]string{
+		"example.com/a/@v/list":              "v0.9.0\n",
+		"example.com/a/@v/v1.0.0-!r!c1.info": `{"Version":"v1.0.0-RC1"}`,
+	}}
+	srv := httptest.NewServer(repo)
+	defer srv.Close()
+
+	n := &NexusPublishCmd{URL: srv.URL, Repo: "go-raw"}
+	n.PosArgs.Archive = src
+	if err := n.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"example.com/a/@v/v1.0.0-!r!c1.mod",
+		"example.com/a/@v/v1.0.0-!r!c1.zip",
+		"example.com/a/@v/list",
+		"sumdb/sum.golang.org/supported",
+	}
+	if got := strings.Join(repo.puts, ","); got != strings.Join(want, ",") {
+		t.Errorf("uploaded %v, want %v", got, strings.Join(want, ","))
+	}
+	if n.skipped != 1 {
+		t.Errorf("skipped %v files, want the existing .info file", n.skipped)
+	}
+	if got := repo.files["example.com/a/@v/list"]; got != "v0.9.0\nv1.0.0-RC1\n" {
+		t.Errorf("list = %q, want the versions of the repository and the archive", got)
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPackProxyConflict(t *testing.T) {
+	defer func(goProxy string) { commonOpts.GoProxy = goProxy }(commonOpts.GoProxy)
+	commonOpts.GoProxy = "https://proxy.golang.org"
+
+	p := &PackCmd{}
+	p.Proxy = "https://proxy.corp.example.com"
+	p.Module = []string{"github.com/jessevdk/go-flags@v1.4.0"}
+	if err := p.Execute(nil); err == nil || !strings.Contains(err.Error(), "--goproxy") {
+		t.Fatalf("error = %v, want --proxy and --goproxy conflict", err)
+	}
+}
@@ -0,0 +1,57 @@
+package packager
+
+import (
+	"archive/zip"
+	"log"
+	"sort"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/archive"
+)
+
+// readArchiveModules returns the module versions (path@version) contained in the download cache of an archive.
+func readArchiveModules(file string) (map[string]struct{}, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	mods := map[string]struct{}{}
+	for _, f := range zr.File {
+		if path, version := ModuleOfCachePath(archive.EntryName(f.Name)); path != "" && version != "" {
+			mods[path+"@"+version] = struct{}{}
+		}
+	}
+	return mods, nil
+}
+
+// inBase reports whether the module version is contained in the --base archive.
+func (p *packer) inBase(mod string) bool {
+	_, ok := p.baseMods[mod]
+	return ok
+}
+
+// warnMissingBase warns about module versions of the base archive which are not part of the resolved modules.
+func (p *packer) warnMissingBase(resolved []string) {
+	resolvedSet := map[string]struct{}{}
+	for _, m := range resolved {
+		resolvedSet[m] = struct{}{}
+	}
+
+	var missing []string
+	for m := range p.baseMods {
+		if _, ok := resolvedSet[m]; !ok {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+
+	sort.Strings(missing)
+	log.Printf("%v %v module versions of the base archive are not part of the new resolution:\n", color.YellowString("warning:"), len(missing))
+	for _, m := range missing {
+		log.Println("\t" + color.YellowString(m))
+	}
+}
@@ -0,0 +1,150 @@
+package packager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sharp/color"
+)
+
+// cleanCacheDir removes all modules of the persistent module cache dir with go clean -modcache,
+// which can remove the read-only files of the module cache.
+func (p *packer) cleanCacheDir(dir string) error {
+	if !folderExists(dir) {
+		return nil
+	}
+	_, err := RunGoCommand(p.Go.Command(dir, dir, "clean", "-modcache"))
+	return err
+}
+
+// addCacheGraph records the module versions of the module graph of workDir, whose .info and .mod
+// files are staged from the --cache-dir. The graph contains all versions go loads to resolve it,
+// which are needed to load the graph from the published modules.
+func (p *packer) addCacheGraph(workDir, cacheDir string) {
+	output, err := RunGoCommand(p.goCommand(workDir, cacheDir, "mod", "graph"))
+	if err != nil {
+		log.Println("failed to get module graph:", color.RedString(err.Error()))
+		return
+	}
+
+	if p.cacheGraph == nil {
+		p.cacheGraph = map[string]struct{}{}
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		for _, m := range strings.Fields(scanner.Text()) {
+			if strings.Contains(m, "@") && !strings.HasPrefix(m, "go@") && !strings.HasPrefix(m, "toolchain@") {
+				p.cacheGraph[m] = struct{}{}
+			}
+		}
+	}
+}
+
+// stageCacheModules copies the files of the module versions used by this run from the persistent
+// cacheDir into modCache, which is packed instead of the whole cacheDir. The .info and .mod files of
+// the module graph are staged, the .zip and .ziphash files and the extracted sources only of the
+// downloaded versions. The list files only contain the staged versions.
+func (p *packer) stageCacheModules(cacheDir, modCache string) error {
+	metadata := map[string]struct{}{}
+	for m := range p.cacheGraph {
+		metadata[m] = struct{}{}
+	}
+	sources := map[string]struct{}{}
+	for _, m := range p.downloaded {
+		if m.Version == "" || m.Error != "" {
+			continue
+		}
+		metadata[m.Path+"@"+m.Version] = struct{}{}
+		if !p.MetadataOnly {
+			sources[m.Path+"@"+m.Version] = struct{}{}
+		}
+	}
+
+	versions := map[string][]string{}
+	for m := range metadata {
+		i := strings.LastIndex(m, "@")
+		modPath, version := m[:i], m[i+1:]
+		exts := []string{".info", ".mod"}
+		if _, ok := sources[m]; ok {
+			exts = append(exts, ".zip", ".ziphash")
+		}
+
+		staged := false
+		for _, ext := range exts {
+			rel := filepath.Join("cache", "download", filepath.FromSlash(EscapePath(modPath)), "@v", EscapePath(version)+ext)
+			if !fileExists(filepath.Join(cacheDir, rel)) {
+				continue
+			}
+			if err := copyFile(filepath.Join(cacheDir, rel), filepath.Join(modCache, rel)); err != nil {
+				return err
+			}
+			staged = true
+		}
+		if staged {
+			versions[modPath] = append(versions[modPath], version)
+		}
+	}
+
+	for m := range sources {
+		src := filepath.Join(cacheDir, filepath.FromSlash(EscapePath(m)))
+		if !folderExists(src) {
+			continue
+		}
+		if err := copyDir(src, filepath.Join(modCache, filepath.FromSlash(EscapePath(m)))); err != nil {
+			return err
+		}
+	}
+
+	for modPath, vs := range versions {
+		if err := stageListFile(cacheDir, modCache, modPath, vs); err != nil {
+			return err
+		}
+	}
+	verboseF("staged %v module versions from cache directory\n", len(metadata))
+	return nil
+}
+
+// stageListFile writes the list file of the module with the versions of its list file in cacheDir
+// which are staged.
+func stageListFile(cacheDir, modCache, modPath string, versions []string) error {
+	rel := filepath.Join("cache", "download", filepath.FromSlash(EscapePath(modPath)), "@v", "list")
+	data, err := os.ReadFile(filepath.Join(cacheDir, rel))
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	staged := map[string]struct{}{}
+	for _, v := range versions {
+		staged[v] = struct{}{}
+	}
+	var b strings.Builder
+	for _, v := range strings.Fields(string(data)) {
+		if _, ok := staged[v]; ok {
+			fmt.Fprintln(&b, v)
+		}
+	}
+	return os.WriteFile(filepath.Join(modCache, rel), []byte(b.String()), 0664)
+}
+
+// copyDir copies all files of the directory src into dst. The files aren't hard linked, as the
+// temporary module cache is made writable for its removal, which would change the permissions of
+// the linked files in the cache directory.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		return copyFile(path, filepath.Join(dst, relPath))
+	})
+}
@@ -0,0 +1,40 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestStagedFilesKeepCachePermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows has no unix permissions")
+	}
+
+	cacheDir, modCache := t.TempDir(), filepath.Join(t.TempDir(), "modcache")
+	src := filepath.Join(cacheDir, "example.com", "a@v1.0.0")
+	if err := os.MkdirAll(src, 0777); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(src, "go.mod")
+	if err := os.WriteFile(file, []byte("module example.com/a\n"), 0444); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := copyDir(src, filepath.Join(modCache, "example.com", "a@v1.0.0")); err != nil {
+		t.Fatal(err)
+	}
+	removeContent(modCache)
+
+	if folderExists(modCache) {
+		t.Error("module cache not removed")
+	}
+	fi, err := os.Stat(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0444 {
+		t.Errorf("mode of the cached file = %v, want 0444", fi.Mode().Perm())
+	}
+}
@@ -0,0 +1,385 @@
+package packager
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sharp/color"
+)
+
+// checkAvailability resolves the module graph without downloading module sources and
+// checks that every module zip is available from the configured proxies.
+func (p *packer) checkAvailability(workDir, modCache string) error {
+	log.Println("resolving modules")
+	mods, err := p.listModules(workDir, modCache)
+	if err != nil {
+		return fmt.Errorf("failed to resolve modules: %w", err)
+	}
+	mods = p.filterExcluded(mods)
+
+	checker, err := p.newProxyChecker(workDir, modCache)
+	if err != nil {
+		return fmt.Errorf("failed to read the proxy configuration: %w", err)
+	}
+	if !checker.hasProxy() {
+		log.Println(color.YellowString("warning:"), "no proxy configured, checking modules with go list")
+	}
+
+	log.Printf("checking availability of %v modules\n", len(mods))
+	var (
+		missing []string
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	modCh := make(chan string)
+	for i := 0; i < p.jobs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range modCh {
+				_, direct, err := checker.check(m)
+				if direct {
+					// Private modules and the direct entry of GOPROXY are fetched by the go command
+					_, err = RunGoCommand(p.goCommand(workDir, modCache, "list", "-m", "-json", m))
+				}
+
+				if err != nil {
+					log.Printf("module not available: %v\n", color.RedString(m))
+					verboseF("%v: %v\n", color.RedString("error"), err)
+					mu.Lock()
+					missing = append(missing, m)
+					mu.Unlock()
+					continue
+				}
+				verboseF("module available: %v\n", color.GreenString(m))
+			}
+		}()
+	}
+
+	for _, m := range mods {
+		modCh <- m
+	}
+	close(modCh)
+	wg.Wait()
+	sort.Strings(missing)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%v of %v modules are not available: %v", len(missing), len(mods), strings.Join(missing, ", "))
+	}
+
+	log.Println("all modules available:", color.GreenString("%v", len(mods)))
+	return nil
+}
+
+// proxyChecker checks the availability of module zips with HEAD requests against the proxies of
+// GOPROXY, following the fallback rules of the go command. Modules matching GONOPROXY (GOPRIVATE)
+// and modules which reach a direct entry of GOPROXY aren't fetched from a proxy, they are checked
+// with go list instead. The requests trust the SSL_CERT_FILE (--ca-cert) of the go commands and
+// authenticate with the credentials of the netrc file.
+type proxyChecker struct {
+	client  *http.Client
+	proxies []proxyEntry
+	noProxy string
+	netrc   map[string]netrcCredentials
+}
+
+// proxyEntry is an entry of GOPROXY, an url or direct or off.
+type proxyEntry struct {
+	url string
+	// fallbackOnError is set if the entry is followed by |, so any error falls back to the next
+	// entry. After a , only not found responses fall back.
+	fallbackOnError bool
+}
+
+type netrcCredentials struct {
+	login, password string
+}
+
+// newProxyChecker returns a proxyChecker for the environment of the go commands.
+func (p *packer) newProxyChecker(workDir, modCache string) (*proxyChecker, error) {
+	cmd := p.goCommand(workDir, modCache, "env", "GOPROXY", "GONOPROXY")
+	output, err := RunGoCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(strings.TrimRight(string(output), "\n"), "\n")
+	if len(lines) != 2 {
+		return nil, fmt.Errorf("unexpected go env output: %q", output)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if certFile := cmdEnv(cmd, "SSL_CERT_FILE"); certFile != "" {
+		pem, err := os.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %v", certFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	netrc, err := readNetrc(cmdEnv(cmd, "NETRC"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyChecker{
+		client:  &http.Client{Timeout: 30 * time.Second, Transport: transport},
+		proxies: parseGoProxy(strings.TrimSpace(lines[0])),
+		noProxy: strings.TrimSpace(lines[1]),
+		netrc:   netrc,
+	}, nil
+}
+
+// hasProxy reports whether GOPROXY contains a proxy url.
+func (c *proxyChecker) hasProxy() bool {
+	for _, e := range c.proxies {
+		if e.url != "direct" && e.url != "off" {
+			return true
+		}
+	}
+	return false
+}
+
+// check checks that the zip of mod is served by the proxies and returns its size, which is -1 if
+// the proxy doesn't report it. direct reports that the module is fetched directly from its
+// repository, which the caller has to check with the go command.
+func (c *proxyChecker) check(mod string) (size int64, direct bool, err error) {
+	i := strings.LastIndex(mod, "@")
+	if matchPrefixPatterns(c.noProxy, mod[:i]) {
+		return -1, true, nil
+	}
+	path, version := EscapePath(mod[:i]), EscapePath(mod[i+1:])
+
+	var errs []string
+	for _, e := range c.proxies {
+		switch e.url {
+		case "direct":
+			return -1, true, nil
+		case "off":
+			errs = append(errs, "module lookup disabled by GOPROXY=off")
+			return -1, false, errors.New(strings.Join(errs, "; "))
+		}
+
+		resp, err := c.head(fmt.Sprintf("%v/%v/@v/%v.zip", e.url, path, version))
+		if err != nil {
+			errs = append(errs, err.Error())
+			if !e.fallbackOnError {
+				break
+			}
+			continue
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusOK {
+			return resp.ContentLength, false, nil
+		}
+		errs = append(errs, fmt.Sprintf("%v: %v", RedactURLs(e.url), resp.Status))
+		if !e.fallbackOnError && resp.StatusCode != http.StatusNotFound && resp.StatusCode != http.StatusGone {
+			break
+		}
+	}
+	return -1, false, errors.New(strings.Join(errs, "; "))
+}
+
+// head sends a HEAD request to url, with the netrc credentials of its host unless the url
+// contains credentials.
+func (c *proxyChecker) head(url string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if cred, ok := c.netrc[req.URL.Hostname()]; ok && req.URL.User == nil {
+		req.SetBasicAuth(cred.login, cred.password)
+	}
+	return c.client.Do(req)
+}
+
+// parseGoProxy returns the entries of the GOPROXY list.
+func parseGoProxy(goproxy string) (entries []proxyEntry) {
+	for goproxy != "" {
+		i := strings.IndexAny(goproxy, ",|")
+		entry, sep := goproxy, byte(0)
+		if i >= 0 {
+			entry, sep, goproxy = goproxy[:i], goproxy[i], goproxy[i+1:]
+		} else {
+			goproxy = ""
+		}
+
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, proxyEntry{url: strings.TrimSuffix(entry, "/"), fallbackOnError: sep == '|'})
+		}
+	}
+	return entries
+}
+
+// matchPrefixPatterns reports whether any path prefix of target matches one of the comma separated
+// glob patterns, like GOPRIVATE and GONOPROXY are matched by the go command.
+func matchPrefixPatterns(globs, target string) bool {
+	for _, glob := range strings.Split(globs, ",") {
+		glob = strings.TrimSuffix(strings.TrimSpace(glob), "/")
+		if glob == "" {
+			continue
+		}
+
+		// Match the glob against as many leading path elements of target as it has
+		prefix := target
+		n := strings.Count(glob, "/")
+		for i := 0; i < len(target); i++ {
+			if target[i] == '/' {
+				if n == 0 {
+					prefix = target[:i]
+					break
+				}
+				n--
+			}
+		}
+		if n > 0 {
+			continue
+		}
+		if matched, _ := path.Match(glob, prefix); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// readNetrc returns the credentials per machine of the netrc file, an empty file uses the netrc
+// file of the user like the go command. A missing file results in no credentials.
+func readNetrc(file string) (map[string]netrcCredentials, error) {
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, nil
+		}
+		file = filepath.Join(home, ".netrc")
+		if runtime.GOOS == "windows" {
+			file = filepath.Join(home, "_netrc")
+		}
+	}
+
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	creds := map[string]netrcCredentials{}
+	var machine string
+	var cred netrcCredentials
+	fields := strings.Fields(string(data))
+	for i := 0; i < len(fields)-1; i += 2 {
+		switch fields[i] {
+		case "machine":
+			if machine != "" {
+				creds[machine] = cred
+			}
+			machine, cred = fields[i+1], netrcCredentials{}
+		case "login":
+			cred.login = fields[i+1]
+		case "password":
+			cred.password = fields[i+1]
+		case "default", "macdef":
+			// Entries after a default or macro definition aren't used by the go command either
+			i = len(fields)
+		}
+	}
+	if machine != "" {
+		creds[machine] = cred
+	}
+	return creds, nil
+}
+
+// cmdEnv returns the last value of key in the environment of cmd.
+func cmdEnv(cmd *exec.Cmd, key string) string {
+	if cmd.Env == nil {
+		return os.Getenv(key)
+	}
+	for i := len(cmd.Env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(cmd.Env[i], key+"=") {
+			return strings.TrimPrefix(cmd.Env[i], key+"=")
+		}
+	}
+	return ""
+}
+
+// dryRun resolves the module graph without downloading module sources and prints every module
+// which would be packed, with an estimate of the total size from the proxies if available.
+func (p *packer) dryRun(workDir, modCache string) error {
+	log.Println("resolving modules")
+	mods, err := p.listModules(workDir, modCache)
+	if err != nil {
+		return fmt.Errorf("failed to resolve modules: %w", err)
+	}
+	mods = p.filterExcluded(mods)
+	sort.Strings(mods)
+
+	for _, m := range mods {
+		fmt.Println(m)
+	}
+
+	checker, err := p.newProxyChecker(workDir, modCache)
+	if err != nil || !checker.hasProxy() {
+		log.Printf("%v modules would be packed\n", len(mods))
+		return nil
+	}
+
+	var (
+		total   int64
+		unknown int
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+	)
+	modCh := make(chan string)
+	for i := 0; i < p.jobs(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for m := range modCh {
+				size, _, err := checker.check(m)
+				if err != nil {
+					verboseF("failed to get size of %v: %v\n", color.YellowString(m), err)
+				}
+
+				mu.Lock()
+				if size < 0 {
+					unknown++
+				} else {
+					total += size
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for _, m := range mods {
+		modCh <- m
+	}
+	close(modCh)
+	wg.Wait()
+
+	log.Printf("%v modules would be packed, estimated size of the module zips: %v\n", len(mods), color.BlueString(ByteSize(total).String()))
+	if unknown > 0 {
+		log.Println(color.YellowString("warning:"), "size unknown for", unknown, "modules")
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package packager
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchPrefixPatterns(t *testing.T) {
+	tests := []struct {
+		globs, target string
+		want          bool
+	}{
+		{globs: "git.corp.example.com", target: "git.corp.example.com/team/lib", want: true},
+		{globs: "*.corp.example.com", target: "git.corp.example.com/team/lib", want: true},
+		{globs: "github.com/corp/*", target: "github.com/corp/lib/v2", want: true},
+		{globs: "github.com/corp/*", target: "github.com/corp", want: false},
+		{globs: "github.com/other, github.com/corp/", target: "github.com/corp/lib", want: true},
+		{globs: "github.com/corp", target: "github.com/corporate/lib", want: false},
+		{globs: "", target: "github.com/corp/lib", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := matchPrefixPatterns(tt.globs, tt.target); got != tt.want {
+			t.Errorf("matchPrefixPatterns(%q, %q) = %v, want %v", tt.globs, tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestProxyChecker(t *testing.T) {
+	goOpts := testGoOptions(t)
+	// The proxy requires the credentials of the netrc file and a certificate of a private CA
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "ci" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/example.com/a/@v/v1.0.0.zip":
+			w.Header().Set("Content-Length", "42")
+		case "/example.com/broken/@v/v1.0.0.zip":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	caCert := filepath.Join(dir, "ca.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	if err := os.WriteFile(caCert, certPEM, 0666); err != nil {
+		t.Fatal(err)
+	}
+	netrc := filepath.Join(dir, "netrc")
+	if err := os.WriteFile(netrc, []byte("machine 127.0.0.1\nlogin ci\npassword secret\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		goproxy string
+		mod     string
+		size    int64
+		direct  bool
+		err     string
+	}{
+		{goproxy: srv.URL, mod: "example.com/a@v1.0.0", size: 42},
+		{goproxy: srv.URL + ",direct", mod: "example.com/missing@v1.0.0", size: -1, direct: true},
+		{goproxy: srv.URL + ",off", mod: "example.com/missing@v1.0.0", size: -1, err: "GOPROXY=off"},
+		{goproxy: srv.URL + ",direct", mod: "example.com/broken@v1.0.0", size: -1, err: "500"},
+		{goproxy: srv.URL + "|direct", mod: "example.com/broken@v1.0.0", size: -1, direct: true},
+		{goproxy: srv.URL, mod: "git.corp.example.com/lib@v1.0.0", size: -1, direct: true},
+	}
+
+	for _, tt := range tests {
+		goOpts := goOpts
+		goOpts.Env = append(append([]string{}, goOpts.Env...), "GOPROXY="+tt.goproxy, "SSL_CERT_FILE="+caCert)
+		p := &packer{PackOptions: PackOptions{Go: goOpts, Netrc: netrc, Private: []string{"git.corp.example.com"}}}
+		checker, err := p.newProxyChecker(dir, dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		size, direct, err := checker.check(tt.mod)
+		if size != tt.size || direct != tt.direct {
+			t.Errorf("GOPROXY=%v check(%v) = %v, %v, want %v, %v", tt.goproxy, tt.mod, size, direct, tt.size, tt.direct)
+		}
+		if tt.err == "" && err != nil || tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+			t.Errorf("GOPROXY=%v check(%v) error = %v, want %q", tt.goproxy, tt.mod, err, tt.err)
+		}
+	}
+}
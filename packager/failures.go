@@ -0,0 +1,90 @@
+package packager
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/go-sharp/color"
+)
+
+// failureCategories are evaluated in order, the first category with a
+// matching phrase is used, otherwise the failure is categorized as other.
+var failureCategories = []struct {
+	name    string
+	phrases []string
+}{
+	{"checksum mismatch", []string{"checksum mismatch", "security error", "verifying module", "verifying go.mod"}},
+	{"auth", []string{"401", "403", "unauthorized", "forbidden", "authentication", "terminal prompts disabled", "could not read username", "permission denied"}},
+	{networkFailure, []string{"timeout", "deadline exceeded", "connection refused", "connection reset", "no such host", "network is unreachable", "tls handshake",
+		"bad gateway", "service unavailable", "unexpected eof"}},
+	{"not found", []string{"404", "410", "not found", "unknown revision", "no matching versions", "invalid version", "does not contain package"}},
+}
+
+const (
+	networkFailure = "network timeout"
+	otherFailure   = "other"
+)
+
+// classifyFailure returns the category of the error message.
+func classifyFailure(msg string) string {
+	msg = strings.ToLower(msg)
+	for _, c := range failureCategories {
+		for _, p := range c.phrases {
+			if strings.Contains(msg, p) {
+				return c.name
+			}
+		}
+	}
+	return otherFailure
+}
+
+// errorHints translate well known go errors into actionable guidance.
+var errorHints = []struct {
+	phrase string
+	hint   string
+}{
+	{"inconsistent vendoring", "the module uses vendoring and vendor/modules.txt doesn't match go.mod: " +
+		"run 'go mod vendor' in the module directory or set GOFLAGS=-mod=mod with --go-env-file to ignore the vendor directory"},
+}
+
+// logErrorHint logs guidance for err if it is a well known go error.
+func logErrorHint(err error) {
+	for _, h := range errorHints {
+		if strings.Contains(err.Error(), h.phrase) {
+			log.Println(color.YellowString("hint:"), h.hint)
+		}
+	}
+}
+
+// logFailureSummary logs the number of failures per category and the failed modules.
+func logFailureSummary(failures []ModuleError) {
+	if len(failures) == 0 {
+		return
+	}
+
+	byCategory := map[string][]string{}
+	for _, f := range failures {
+		c := classifyFailure(f.Err)
+		byCategory[c] = append(byCategory[c], f.Module)
+	}
+
+	var categories []string
+	for c := range byCategory {
+		categories = append(categories, c)
+	}
+	sort.Slice(categories, func(i, j int) bool {
+		if len(byCategory[categories[i]]) != len(byCategory[categories[j]]) {
+			return len(byCategory[categories[i]]) > len(byCategory[categories[j]])
+		}
+		return categories[i] < categories[j]
+	})
+
+	log.Printf("%v modules failed:\n", color.RedString("%v", len(failures)))
+	for _, c := range categories {
+		log.Printf("\t%v: %v\n", c, color.RedString("%v", len(byCategory[c])))
+		for _, m := range byCategory[c] {
+			log.Printf("\t\t%v\n", m)
+		}
+	}
+}
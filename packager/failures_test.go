@@ -0,0 +1,73 @@
+package packager
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testGoOptions returns the go options of the go commands run by tests, which don't use the
+// network and aren't affected by the GOFLAGS of the test run.
+func testGoOptions(t *testing.T) GoOptions {
+	t.Helper()
+	goBin, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go binary not found")
+	}
+	return GoOptions{Bin: goBin, Env: []string{"GOFLAGS=", "GOPROXY=off", "GOSUMDB=off", "GOTOOLCHAIN=local"}}
+}
+
+// captureLog returns the buffer the standard logger writes to until the test is done.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	w, prefix, flags := log.Writer(), log.Prefix(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetPrefix("")
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(w)
+		log.SetPrefix(prefix)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+func TestInconsistentVendoringHint(t *testing.T) {
+	goOpts := testGoOptions(t)
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/vendored\n\ngo 1.17\n\nrequire github.com/jessevdk/go-flags v1.4.0\n",
+		"main.go": "package main\n\nimport _ \"github.com/jessevdk/go-flags\"\n\nfunc main() {}\n",
+		// modules.txt of an older go-flags version, go.mod was updated without go mod vendor
+		"vendor/modules.txt": "# github.com/jessevdk/go-flags v1.3.0\n## explicit\ngithub.com/jessevdk/go-flags\n",
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logs := captureLog(t)
+	_, err := Pack(PackOptions{
+		ModFile:    []string{filepath.Join(dir, "go.mod")},
+		NoTestDeps: true,
+		Output:     filepath.Join(t.TempDir(), "gop_dependencies.zip"),
+		Go:         goOpts,
+		TempDir:    t.TempDir(),
+	})
+	if err == nil || !strings.Contains(err.Error(), "inconsistent vendoring") {
+		t.Fatalf("error = %v, want inconsistent vendoring", err)
+	}
+	if !strings.Contains(logs.String(), "hint:") || !strings.Contains(logs.String(), "go mod vendor") {
+		t.Errorf("log doesn't contain the vendoring hint:\n%s", logs)
+	}
+}
@@ -0,0 +1,145 @@
+package packager
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/go-sharp/color"
+)
+
+// DefaultGoVersion is the go version of the temporary go.mod, if the version of the go binary is unknown.
+const DefaultGoVersion = "1.13"
+
+var goDirectiveRegex = regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
+
+// TempGoMod returns the content of the temporary go.mod with the given go version.
+func TempGoMod(goVersion string) string {
+	return fmt.Sprintf("module go-offline-packager\n\ngo %v\n", goVersion)
+}
+
+// languageVersion returns the major and minor version of a go version (ex. 1.22 for 1.22.3).
+func languageVersion(version string) string {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 {
+		return version
+	}
+	return parts[0] + "." + strconv.Itoa(leadingInt(parts[1]))
+}
+
+var goVersionRegex = regexp.MustCompile(`go version go(\d+(?:\.\d+)*)`)
+
+// checkGoVersion reports the go.mod and go.work files which require a newer go version than the
+// go binary, which otherwise fails with confusing errors while resolving the modules. The error
+// is only returned with --strict-go-version. Go 1.21 and newer switch to the required toolchain
+// themselves, which is only reported as warning.
+func (p *packer) checkGoVersion(files []string) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	installed, err := installedGoVersion(p.Go)
+	if err != nil {
+		verboseF("can't determine go version: %v\n", err)
+		return nil
+	}
+
+	for _, file := range files {
+		required, err := goDirective(file)
+		if err != nil || required == "" || compareGoVersions(installed, required) >= 0 {
+			continue
+		}
+
+		if compareGoVersions(installed, "1.21") >= 0 && goToolchainSwitches(p.Go) {
+			log.Printf("%v %v requires go %v, go %v switches to a downloaded toolchain, which requires network access\n",
+				color.YellowString("warning:"), file, required, installed)
+			continue
+		}
+
+		msg := fmt.Sprintf("%v requires go %v, but %v is go %v, use --go-bin to specify a newer go binary", file, required, p.Go.Bin, installed)
+		if p.StrictGoVersion {
+			return errors.New(msg)
+		}
+		log.Println(errorRedPrefix, color.RedString(msg))
+	}
+	return nil
+}
+
+// installedGoVersion returns the version of the go binary (ex. 1.22.3).
+func installedGoVersion(g GoOptions) (string, error) {
+	// A go.mod file in the working directory must not switch the toolchain
+	cmd := g.Command(os.TempDir(), "", "version")
+	cmd.Env = append(cmd.Env, "GOTOOLCHAIN=local")
+	output, err := RunGoCommand(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	match := goVersionRegex.FindSubmatch(output)
+	if match == nil {
+		return "", fmt.Errorf("unknown go version: %s", bytes.TrimSpace(output))
+	}
+	return string(match[1]), nil
+}
+
+// goToolchainSwitches reports whether GOTOOLCHAIN allows go to switch to a newer toolchain.
+func goToolchainSwitches(g GoOptions) bool {
+	output, err := RunGoCommand(g.Command(os.TempDir(), "", "env", "GOTOOLCHAIN"))
+	if err != nil {
+		return false
+	}
+	tc := string(bytes.TrimSpace(output))
+	return strings.Contains(tc, "auto") || strings.Contains(tc, "path")
+}
+
+// goDirective returns the version of the go directive of a go.mod or go.work file.
+func goDirective(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 && fields[0] == "go" {
+			return fields[1], nil
+		}
+	}
+	return "", nil
+}
+
+// compareGoVersions compares the go versions a and b (ex. 1.21 or 1.22.3) and returns -1, 0 or 1.
+// Missing parts are treated as 0 and pre-release suffixes (ex. rc1) are ignored.
+func compareGoVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x = leadingInt(as[i])
+		}
+		if i < len(bs) {
+			y = leadingInt(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// leadingInt returns the number at the start of s, ex. 21 for 21rc1.
+func leadingInt(s string) int {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+	n, _ := strconv.Atoi(s[:i])
+	return n
+}
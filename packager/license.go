@@ -0,0 +1,151 @@
+package packager
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-sharp/color"
+)
+
+const unknownLicense = "unknown"
+
+var licenseFileNames = []string{"LICENSE", "LICENCE", "COPYING", "LICENSE-MIT", "LICENSE-APACHE"}
+
+// licenseMatchers are evaluated in order, the first license whose phrases are
+// all contained in the license text wins.
+var licenseMatchers = []struct {
+	license string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"GNU AFFERO GENERAL PUBLIC LICENSE"}},
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-2.1", []string{"GNU LESSER GENERAL PUBLIC LICENSE"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "2.0"}},
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"ISC", []string{"Permission to use, copy, modify, and/or distribute this software for any"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "names of its contributors"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"Unlicense", []string{"This is free and unencumbered software"}},
+	{"CC0-1.0", []string{"CC0 1.0 Universal"}},
+}
+
+// detectLicenses returns the license of every module extracted in modCache keyed by
+// module@version. The detection is best-effort, modules without a recognized
+// license file are reported as unknown.
+func detectLicenses(modCache string) (map[string]string, error) {
+	licenses := map[string]string{}
+	err := filepath.Walk(modCache, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !info.IsDir() {
+			return nil
+		}
+
+		if path == filepath.Join(modCache, "cache") {
+			return filepath.SkipDir
+		}
+
+		if !strings.Contains(info.Name(), "@") {
+			return nil
+		}
+
+		licenses[UnescapePath(RelSlashPath(modCache, path))] = detectLicense(path)
+		return filepath.SkipDir
+	})
+
+	return licenses, err
+}
+
+func detectLicense(modDir string) string {
+	entries, err := os.ReadDir(modDir)
+	if err != nil {
+		return unknownLicense
+	}
+
+	for _, e := range entries {
+		name := strings.ToUpper(strings.TrimSuffix(e.Name(), filepath.Ext(e.Name())))
+		if e.IsDir() || !isLicenseFileName(name) {
+			continue
+		}
+
+		content, err := os.ReadFile(filepath.Join(modDir, e.Name()))
+		if err != nil {
+			continue
+		}
+
+		text := strings.Join(strings.Fields(string(content)), " ")
+		for _, m := range licenseMatchers {
+			if containsAll(text, m.phrases) {
+				return m.license
+			}
+		}
+	}
+
+	return unknownLicense
+}
+
+func isLicenseFileName(name string) bool {
+	for _, n := range licenseFileNames {
+		if name == n {
+			return true
+		}
+	}
+	return false
+}
+
+func containsAll(text string, phrases []string) bool {
+	for _, p := range phrases {
+		if !strings.Contains(strings.ToLower(text), strings.ToLower(p)) {
+			return false
+		}
+	}
+	return true
+}
+
+// logLicenseSummary logs the number of modules per license and
+// the modules without a detectable license.
+func logLicenseSummary(licenses map[string]string) {
+	counts := map[string]int{}
+	var unknown []string
+	for mod, l := range licenses {
+		counts[l]++
+		if l == unknownLicense {
+			unknown = append(unknown, mod)
+		}
+	}
+
+	var names []string
+	for l := range counts {
+		names = append(names, l)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	var summary []string
+	for _, l := range names {
+		summary = append(summary, fmt.Sprintf("%v %v", counts[l], l))
+	}
+	log.Println("licenses:", color.BlueString(strings.Join(summary, ", ")))
+
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		log.Printf("%v no license detected, review manually:\n", color.YellowString("warning:"))
+		for _, m := range unknown {
+			log.Println("\t" + color.YellowString(m))
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package packager
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+// manifest is the machine-readable record of the packed modules written with --manifest.
+type manifest struct {
+	Tool    string    `json:"tool"`
+	Created time.Time `json:"created"`
+	Modules []Module  `json:"modules"`
+}
+
+// manifestModules returns the downloaded and the failed modules sorted by path and version.
+// Modules left out of the archive by include are skipped, a module downloaded by several
+// toolchains is listed once and without error if any download succeeded.
+func (p *packer) manifestModules(include func(string) bool) []Module {
+	mods := map[string]Module{}
+	add := func(m Module) {
+		key := m.Path + "@" + m.Version
+		if prev, ok := mods[key]; ok && prev.Error == "" {
+			return
+		}
+		mods[key] = m
+	}
+
+	for _, m := range p.downloaded {
+		if m.Version == "" {
+			continue
+		}
+		if include != nil && !include("cache/download/"+EscapePath(m.Path)+"/@v/"+EscapePath(m.Version)+".mod") {
+			continue
+		}
+		add(Module{Path: m.Path, Version: m.Version, Error: m.Error, Sum: m.Sum, GoModSum: m.GoModSum, Origin: m.Origin})
+	}
+
+	for _, f := range p.failures {
+		path, version := f.Module, ""
+		if i := strings.LastIndex(f.Module, "@"); i > 0 {
+			path, version = f.Module[:i], f.Module[i+1:]
+		}
+		add(Module{Path: path, Version: version, Error: f.Err})
+	}
+
+	list := make([]Module, 0, len(mods))
+	for _, m := range mods {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Version < list[j].Version
+	})
+	return list
+}
+
+// writeManifest writes the manifest of the modules as indented JSON to file, - writes it to stdout.
+func writeManifest(file string, mods []Module) error {
+	m := manifest{Tool: "go-offline-packager " + Version, Created: time.Now().UTC(), Modules: mods}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeDataFile(file, append(data, '\n'))
+}
@@ -0,0 +1,200 @@
+package packager
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Module is a module of the module cache, its fields correspond to
+// the output of go mod download -json.
+type Module struct {
+	Path     string  `json:"path"`
+	Version  string  `json:"version"`
+	Error    string  `json:"error,omitempty"`
+	Info     string  `json:"-"`
+	GoMod    string  `json:"-"`
+	Dir      string  `json:"-"`
+	Zip      string  `json:"-"`
+	Sum      string  `json:"sum,omitempty"`
+	GoModSum string  `json:"goModSum,omitempty"`
+	Origin   *Origin `json:"origin,omitempty"`
+}
+
+// Origin is the provenance of a module version reported by go mod download -json (go 1.19+).
+// It describes the repository the version was resolved from, not the proxy that served it,
+// and is missing if the proxy does not provide it.
+type Origin struct {
+	VCS    string `json:"vcs,omitempty"`
+	URL    string `json:"url,omitempty"`
+	Subdir string `json:"subdir,omitempty"`
+	Hash   string `json:"hash,omitempty"`
+	Ref    string `json:"ref,omitempty"`
+}
+
+func (o Origin) String() string {
+	s := strings.TrimSpace(o.VCS + " " + o.URL)
+	if o.Subdir != "" {
+		s += " (" + o.Subdir + ")"
+	}
+	if o.Ref != "" {
+		s += " " + o.Ref
+	}
+	if o.Hash != "" {
+		s += " " + o.Hash
+	}
+	return s
+}
+
+// decodeModules decodes the stream of JSON objects written by go mod download -json.
+func decodeModules(r io.Reader) ([]Module, error) {
+	var mods []Module
+	dec := json.NewDecoder(r)
+	for {
+		var m struct {
+			Path, Version, Info, GoMod, Dir, Zip, Sum, GoModSum string
+			// Error is a string for go mod download and an object for go list -m
+			Error  json.RawMessage
+			Origin *Origin
+		}
+		if err := dec.Decode(&m); err == io.EOF {
+			return mods, nil
+		} else if err != nil {
+			return mods, err
+		}
+
+		var errStr string
+		if len(m.Error) > 0 && json.Unmarshal(m.Error, &errStr) != nil {
+			var listErr struct{ Err string }
+			_ = json.Unmarshal(m.Error, &listErr)
+			errStr = listErr.Err
+		}
+
+		mods = append(mods, Module{Path: m.Path, Version: m.Version, Error: errStr,
+			Info: m.Info, GoMod: m.GoMod, Dir: m.Dir, Zip: m.Zip, Sum: m.Sum, GoModSum: m.GoModSum, Origin: m.Origin})
+	}
+}
+
+// CollectCacheModules returns all module versions found in the download cache of modCache,
+// sorted by path and version. Sum and GoModSum are computed from the cached files.
+func CollectCacheModules(modCache string) ([]Module, error) {
+	dlDir := filepath.Join(modCache, "cache", "download")
+	var mods []Module
+	err := filepath.Walk(dlDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == "sumdb" && filepath.Dir(path) == dlDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !strings.HasSuffix(path, ".mod") || filepath.Base(filepath.Dir(path)) != "@v" {
+			return nil
+		}
+
+		base := strings.TrimSuffix(path, ".mod")
+		mod := Module{
+			Path:    UnescapePath(RelSlashPath(dlDir, filepath.Dir(filepath.Dir(path)))),
+			Version: UnescapePath(filepath.Base(base)),
+			GoMod:   path,
+		}
+
+		if mod.GoModSum, err = hashGoMod(path); err != nil {
+			mod.Error = err.Error()
+		}
+		if fileExists(base + ".info") {
+			mod.Info = base + ".info"
+		}
+		if fileExists(base + ".zip") {
+			mod.Zip = base + ".zip"
+			if sum, err := os.ReadFile(base + ".ziphash"); err == nil {
+				mod.Sum = strings.TrimSpace(string(sum))
+			} else if mod.Sum, err = HashZip(mod.Zip); err != nil {
+				mod.Error = err.Error()
+			}
+		}
+
+		mods = append(mods, mod)
+		return nil
+	})
+
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+
+	sort.Slice(mods, func(i, j int) bool {
+		if mods[i].Path != mods[j].Path {
+			return mods[i].Path < mods[j].Path
+		}
+		return mods[i].Version < mods[j].Version
+	})
+	return mods, err
+}
+
+// HashZip returns the h1: hash of a module zip, computed the same way as
+// the go command does for .ziphash files and go.sum entries.
+func HashZip(file string) (string, error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return "", err
+	}
+	defer zr.Close()
+
+	files := map[string]*zip.File{}
+	var names []string
+	for _, f := range zr.File {
+		if strings.Contains(f.Name, "\n") {
+			return "", fmt.Errorf("filename with newline not supported: %q", f.Name)
+		}
+		files[f.Name] = f
+		names = append(names, f.Name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		r, err := files[name].Open()
+		if err != nil {
+			return "", err
+		}
+
+		fh := sha256.New()
+		_, err = io.Copy(fh, r)
+		r.Close()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%x  %s\n", fh.Sum(nil), name)
+	}
+
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashGoMod returns the h1: hash of a go.mod file as used for the /go.mod entries of go.sum.
+func hashGoMod(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%x  %s\n", sha256.Sum256(data), "go.mod")
+	return "h1:" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+func fileExists(name string) bool {
+	fi, err := os.Stat(name)
+	return err == nil && !fi.IsDir()
+}
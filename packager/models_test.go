@@ -0,0 +1,75 @@
+package packager
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeModules(t *testing.T) {
+	// go mod download -json writes indented objects one after another, go list -m -json
+	// reports errors as object
+	stream := `{
+	"Path": "github.com/jessevdk/go-flags",
+	"Version": "v1.4.0",
+	"Info": "/cache/github.com/jessevdk/go-flags/@v/v1.4.0.info",
+	"GoMod": "/cache/github.com/jessevdk/go-flags/@v/v1.4.0.mod",
+	"Zip": "/cache/github.com/jessevdk/go-flags/@v/v1.4.0.zip",
+	"Dir": "/cache/github.com/jessevdk/go-flags@v1.4.0",
+	"Sum": "h1:4KAMxn3uhrSbDQ9ITqP2rcMM1VZiuo3U3dTN4bILL7s=",
+	"GoModSum": "h1:ZpqdT6q1VEZ0zGbP2GQ1AucYV6sBPxuJe6MAWuQMqL8=",
+	"Origin": {
+		"VCS": "git",
+		"URL": "https://github.com/jessevdk/go-flags",
+		"Ref": "refs/tags/v1.4.0"
+	}
+}
+{
+	"Path": "example.com/missing",
+	"Version": "v1.0.0",
+	"Error": "example.com/missing@v1.0.0: reading https://proxy.golang.org/example.com/missing/@v/v1.0.0.info: 404 Not Found"
+}{"Path":"example.com/list","Version":"v0.1.0","Error":{"Err":"module example.com/list: not found"}}
+{"Path":"golang.org/x/sys","Version":"v0.0.0-20191026070338-33540a1f6037"}
+`
+	mods, err := decodeModules(strings.NewReader(stream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Module{
+		{
+			Path: "github.com/jessevdk/go-flags", Version: "v1.4.0",
+			Info:     "/cache/github.com/jessevdk/go-flags/@v/v1.4.0.info",
+			GoMod:    "/cache/github.com/jessevdk/go-flags/@v/v1.4.0.mod",
+			Zip:      "/cache/github.com/jessevdk/go-flags/@v/v1.4.0.zip",
+			Dir:      "/cache/github.com/jessevdk/go-flags@v1.4.0",
+			Sum:      "h1:4KAMxn3uhrSbDQ9ITqP2rcMM1VZiuo3U3dTN4bILL7s=",
+			GoModSum: "h1:ZpqdT6q1VEZ0zGbP2GQ1AucYV6sBPxuJe6MAWuQMqL8=",
+			Origin:   &Origin{VCS: "git", URL: "https://github.com/jessevdk/go-flags", Ref: "refs/tags/v1.4.0"},
+		},
+		{Path: "example.com/missing", Version: "v1.0.0", Error: "example.com/missing@v1.0.0: reading https://proxy.golang.org/example.com/missing/@v/v1.0.0.info: 404 Not Found"},
+		{Path: "example.com/list", Version: "v0.1.0", Error: "module example.com/list: not found"},
+		{Path: "golang.org/x/sys", Version: "v0.0.0-20191026070338-33540a1f6037"},
+	}
+	if !reflect.DeepEqual(mods, want) {
+		t.Errorf("decodeModules() = %+v, want %+v", mods, want)
+	}
+}
+
+func TestDecodeModulesInvalid(t *testing.T) {
+	mods, err := decodeModules(strings.NewReader(`{"Path":"example.com/a","Version":"v1.0.0"}
+{"Path": "example.com/b",`))
+	if err == nil {
+		t.Fatal("decoding a truncated stream succeeded")
+	}
+	if len(mods) != 1 || mods[0].Path != "example.com/a" {
+		t.Errorf("decoded %+v before the error, want example.com/a", mods)
+	}
+}
+
+func TestDecodeModulesEmpty(t *testing.T) {
+	mods, err := decodeModules(strings.NewReader(""))
+	if err != nil || len(mods) != 0 {
+		t.Errorf("decodeModules(\"\") = %v, %v, want no modules", mods, err)
+	}
+}
@@ -0,0 +1,1647 @@
+package packager
+
+import (
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-sharp/color"
+)
+
+type packer struct {
+	PackOptions
+
+	// toolchain is the GOTOOLCHAIN used for go commands, empty for the installed go binary.
+	toolchain string
+	// platform is the GOOS/GOARCH used for go commands, empty for the host platform.
+	platform string
+	// gopath is the GOPATH used for go commands, so the checksum database state can be bundled.
+	gopath string
+	// downloaded contains the modules reported by go mod download.
+	downloaded []Module
+	// failures contains the modules failed to resolve or download.
+	failures []ModuleError
+	// modFile is the go.mod file currently processed.
+	modFile string
+	// requested contains the resolved module@version of the -m modules added to go.mod.
+	requested []string
+	// selected contains the module@version selected in the module graphs for --prune.
+	selected map[string]struct{}
+	// cacheGraph contains the module@version of the module graphs resolved with --cache-dir.
+	cacheGraph map[string]struct{}
+	// baseMods contains the module versions of the --base archive.
+	baseMods map[string]struct{}
+	// graph contains the collected edges of the module graph.
+	graph    []graphEdge
+	graphSet map[graphEdge]struct{}
+}
+
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// pack validates the options, downloads the modules and creates the archive. It returns the
+// archive, which is empty if no single archive was created.
+func (p *packer) pack() (archivePath string, err error) {
+	if f, err := os.Stat(p.Go.Bin); err != nil || f.IsDir() {
+		return "", errors.New("missing go binary, install go or specify path to go binary")
+	}
+	files := append([]string{}, p.ModFile...)
+	if p.Work != "" {
+		files = append(files, p.Work)
+	}
+	if err := p.checkGoVersion(files); err != nil {
+		return "", err
+	}
+	if p.FromBinary != "" {
+		mods, err := modulesFromBinary(p.FromBinary)
+		if err != nil {
+			return "", fmt.Errorf("failed to read build info: %w", err)
+		}
+		p.Module = append(p.Module, mods...)
+	}
+	if mods, err := expandStdinModules(p.Module); err != nil {
+		return "", fmt.Errorf("failed to read modules from stdin: %w", err)
+	} else {
+		p.Module = mods
+	}
+
+	if p.CacheDir != "" {
+		// GOMODCACHE must be an absolute path
+		dir, err := filepath.Abs(p.CacheDir)
+		if err != nil {
+			return "", fmt.Errorf("invalid cache directory: %w", err)
+		}
+		p.CacheDir = dir
+	}
+	if p.CacheClean {
+		if p.CacheDir == "" {
+			return "", errors.New("--cache-clean requires --cache-dir")
+		}
+		log.Println("cleaning cache directory", color.BlueString(p.CacheDir))
+		if err := p.cleanCacheDir(p.CacheDir); err != nil {
+			return "", fmt.Errorf("failed to clean cache directory: %w", err)
+		}
+		if len(p.Module) == 0 && len(p.ModFile) == 0 && len(p.ModuleDeps) == 0 && p.Work == "" && p.Vendor == "" {
+			return "", nil
+		}
+	}
+
+	if len(p.Module) == 0 && len(p.ModFile) == 0 && len(p.ModuleDeps) == 0 && p.Work == "" && p.Vendor == "" {
+		return "", errors.New("either modul, go.mod, go.work file or vendor directory required")
+	}
+	if p.Vendor != "" && (len(p.Module) > 0 || len(p.ModFile) > 0 || len(p.ModuleDeps) > 0 || p.Work != "" || p.DoTransitive || p.NoTestDeps || p.TestDeps || len(p.CoverGoVersions) > 0 || len(p.Platforms) > 0 || p.CacheDir != "" || p.Prune ||
+		p.GraphJSON != "" || p.SplitByModule || p.NoDownload || p.DryRun || p.MetadataOnly) {
+		return "", errors.New("--vendor can't be used with options resolving or downloading modules")
+	}
+	if p.Work != "" && (len(p.Module) > 0 || len(p.ModFile) > 0 || len(p.ModuleDeps) > 0 || p.NoTestDeps || p.TestDeps) {
+		return "", errors.New("--work can't be used with -m, -g, --module-deps, --no-test-deps or --test-deps")
+	}
+	if len(p.IgnoreReplace) > 0 && len(p.ModFile) == 0 && len(p.ModuleDeps) == 0 {
+		return "", errors.New("--ignore-replace requires -g or --module-deps")
+	}
+	if p.NoTestDeps && p.TestDeps {
+		return "", errors.New("--no-test-deps can't be used with --test-deps")
+	}
+	if p.GoVersion != "" {
+		p.GoVersion = strings.TrimPrefix(strings.TrimSpace(p.GoVersion), "go")
+		if !goDirectiveRegex.MatchString(p.GoVersion) {
+			return "", fmt.Errorf("invalid go version: %v", p.GoVersion)
+		}
+	}
+	for _, pf := range p.Platforms {
+		if goos, goarch, ok := splitPlatform(pf); !ok || goos == "" || goarch == "" {
+			return "", fmt.Errorf("invalid platform, expected GOOS/GOARCH: %v", pf)
+		}
+	}
+	if p.Publish != nil && p.SplitByModule {
+		return "", errors.New("publishing can't be used with --split-by-module")
+	}
+	if p.SplitSize > 0 && (p.Output == "-" || p.Publish != nil) {
+		return "", errors.New("--split-size requires an output file")
+	}
+	toStdout := 0
+	for _, f := range []string{p.Output, p.Manifest, p.GraphJSON} {
+		if f == "-" {
+			toStdout++
+		}
+	}
+	if toStdout > 1 {
+		return "", errors.New("only one of --out, --manifest and --graph-json can write to stdout")
+	}
+	if p.Sum && (p.Output == "-" || p.Publish != nil) {
+		return "", errors.New("--sum requires an output file")
+	}
+	if p.SplitByModule && (len(p.ModFile) > 0 || len(p.ModuleDeps) > 0 || p.Output == "-") {
+		return "", errors.New("--split-by-module requires modules specified with -m and an output file")
+	}
+	if p.Base != "" {
+		mods, err := readArchiveModules(p.Base)
+		if err != nil {
+			return "", fmt.Errorf("failed to read base archive: %w", err)
+		}
+		p.baseMods = mods
+		log.Printf("base archive contains %v module versions\n", len(mods))
+	}
+
+	if p.Jobs < 1 {
+		log.Printf("%v invalid number of jobs %v, using %v\n", color.YellowString("warning:"), p.Jobs, DefaultJobs)
+	}
+	if p.Netrc != "" {
+		netrc, err := filepath.Abs(p.Netrc)
+		if err != nil || !fileExists(netrc) {
+			return "", fmt.Errorf("netrc file not found: %v", p.Netrc)
+		}
+		p.Netrc = netrc
+	}
+	if p.Proxy != "" {
+		verboseF("proxy: %v\n", color.BlueString(RedactURLs(p.Proxy)))
+	}
+	if len(p.Private) > 0 {
+		verboseF("private modules: %v\n", color.BlueString(strings.Join(p.Private, ",")))
+	}
+	log.Println("prepare dependencies")
+
+	workDir, cleanFn, err := CreateTempWorkDir(p.TempDir)
+	if err != nil {
+		return "", err
+	}
+	defer cleanFn()
+
+	modCache := filepath.Join(workDir, "modcache")
+	if err := os.Mkdir(modCache, 0774); err != nil {
+		return "", fmt.Errorf("failed to create mod cache directory: %w", err)
+	}
+	p.gopath = filepath.Join(workDir, "gopath")
+
+	// With --cache-dir the modules are downloaded into the persistent cache and the ones of this
+	// run are staged into modCache afterwards
+	downloadCache := modCache
+	if p.CacheDir != "" {
+		if err := os.MkdirAll(p.CacheDir, 0774); err != nil {
+			return "", fmt.Errorf("failed to create cache directory: %w", err)
+		}
+		verboseF("cache directory: %v\n", color.BlueString(p.CacheDir))
+		downloadCache = p.CacheDir
+	}
+
+	if p.Vendor != "" {
+		log.Println("creating module cache from vendor directory")
+		if err := createVendorCache(p.Vendor, modCache); err != nil {
+			return "", fmt.Errorf("failed to pack vendor directory: %w", err)
+		}
+	} else if done, err := p.downloadModules(workDir, downloadCache); err != nil || done {
+		return "", err
+	}
+
+	if downloadCache != modCache {
+		if err := p.stageCacheModules(downloadCache, modCache); err != nil {
+			return "", fmt.Errorf("failed to stage modules from cache directory: %w", err)
+		}
+	}
+
+	if p.Prune {
+		p.pruneUnselected(modCache)
+	}
+
+	if err := bundleSumDB(modCache, p.gopath); err != nil {
+		log.Println("failed to bundle checksum database:", color.RedString(err.Error()))
+	}
+
+	if p.GraphJSON != "" {
+		if !p.DoTransitive {
+			if output, err := RunGoCommand(p.goCommand(workDir, modCache, "mod", "graph")); err == nil {
+				p.addGraphEdges(output)
+			} else {
+				log.Println("failed to get module graph:", color.RedString(err.Error()))
+			}
+		}
+
+		if err := p.writeGraph(); err != nil {
+			log.Println("failed to write module graph:", color.RedString(err.Error()))
+		} else {
+			log.Println("module graph written:", color.GreenString(dataFileName(p.GraphJSON)))
+		}
+	}
+
+	if p.Licenses && p.MetadataOnly {
+		log.Println(color.YellowString("warning:"), "licenses can't be detected without module sources")
+	} else if p.Licenses {
+		log.Println("detecting licenses")
+		licenses, err := detectLicenses(modCache)
+		if err != nil {
+			log.Println("failed to detect licenses:", color.RedString(err.Error()))
+		}
+		logLicenseSummary(licenses)
+	}
+
+	if p.VerboseSummary {
+		logFailureSummary(p.failures)
+	}
+
+	if p.SBOM != "" {
+		p.writeSBOM(modCache)
+	}
+
+	include, err := p.archiveFilter(modCache)
+	if err != nil {
+		return "", err
+	}
+	if p.Manifest != "" {
+		if err := writeManifest(p.Manifest, p.manifestModules(include)); err != nil {
+			log.Println("failed to write manifest:", color.RedString(err.Error()))
+		} else {
+			log.Println("manifest written:", color.GreenString(dataFileName(p.Manifest)))
+		}
+	}
+
+	if p.Reproducible {
+		if err := sortListFiles(modCache); err != nil {
+			return "", fmt.Errorf("failed to sort list files: %w", err)
+		}
+	}
+
+	if p.MaxFileSize > 0 {
+		if err := trimLargeFiles(modCache, int64(p.MaxFileSize)); err != nil {
+			return "", fmt.Errorf("failed to skip large files: %w", err)
+		}
+	}
+
+	if p.Publish != nil {
+		if err := p.publish(modCache, include); err != nil {
+			return "", err
+		}
+		return "", p.failureErr()
+	}
+
+	if p.SplitByModule {
+		if err := p.createSplitArchives(workDir, modCache, include); err != nil {
+			return "", err
+		}
+		return "", p.failureErr()
+	}
+
+	log.Println("creating archive")
+	opts := zipOptions{Include: include, IgnoreErrors: p.IgnoreErrors, Compression: p.Compression,
+		Reproducible: p.Reproducible, VolumeSize: int64(p.SplitSize)}
+	if err := createZipArchive(modCache, p.Output, opts); err != nil {
+		return "", fmt.Errorf("failed to create zip archive with dependencies: %w", err)
+	}
+	log.Println("archive created:", color.GreenString(p.Output))
+	if err := p.writeSum(p.Output); err != nil {
+		return "", err
+	}
+	if p.Output != "-" {
+		if archivePath, err = filepath.Abs(p.Output); err != nil {
+			archivePath = p.Output
+		}
+	}
+	return archivePath, p.failureErr()
+}
+
+// writeSum writes the SHA256 of the archive (a line per volume) to <archive>.sha256 if --sum is set.
+// The file has the format of sha256sum with the base names of the files, so it can be verified with
+// sha256sum -c next to the archive.
+func (p *packer) writeSum(archive string) error {
+	if !p.Sum {
+		return nil
+	}
+
+	var b strings.Builder
+	for _, file := range ArchiveVolumes(archive) {
+		sum, err := FileSHA256(file)
+		if err != nil {
+			return fmt.Errorf("failed to compute checksum of archive: %w", err)
+		}
+		fmt.Fprintf(&b, "%v  %v\n", sum, filepath.Base(file))
+		verboseF("sha256 of %v: %v\n", filepath.Base(file), sum)
+	}
+	if err := os.WriteFile(archive+".sha256", []byte(b.String()), 0664); err != nil {
+		return fmt.Errorf("failed to write checksum file: %w", err)
+	}
+	log.Println("checksum written:", color.GreenString(archive+".sha256"))
+	return nil
+}
+
+// checkRequested records the requested modules missing in the download cache as failed, so an
+// archive without them isn't reported as success. Excluded modules and modules of the base
+// archive are expected to be missing.
+func (p *packer) checkRequested(modCache string) {
+	failed := map[string]struct{}{}
+	for _, f := range p.failures {
+		failed[f.Module] = struct{}{}
+	}
+
+	for _, m := range p.requested {
+		i := strings.LastIndex(m, "@")
+		if i < 0 || p.isExcluded(m) || p.inBase(m) {
+			continue
+		}
+		if _, ok := failed[m]; ok {
+			continue
+		}
+
+		base := filepath.Join(modCache, "cache", "download", EscapePath(m[:i]), "@v", EscapePath(m[i+1:]))
+		if !fileExists(base+".mod") || !p.MetadataOnly && !fileExists(base+".zip") {
+			log.Printf("requested module missing in module cache: %v\n", color.RedString(m))
+			p.addFailure(m, "module missing in module cache after download")
+		}
+	}
+}
+
+// failureErr logs the modules which ultimately failed and returns an error, unless --keep-going is set.
+func (p *packer) failureErr() error {
+	if len(p.failures) == 0 {
+		return nil
+	}
+
+	if !p.VerboseSummary {
+		log.Printf("%v modules failed:\n", color.RedString("%v", len(p.failures)))
+		for _, f := range p.failures {
+			log.Printf("\t%v\n", f.Module)
+		}
+	}
+	if p.KeepGoing {
+		return nil
+	}
+	return &ModulesError{Failed: p.failures}
+}
+
+// downloadModules resolves the modules to pack and downloads them into modCache. It reports
+// true if the command is done, because only the resolved modules were checked or printed.
+func (p *packer) downloadModules(workDir, modCache string) (done bool, err error) {
+	if p.Work != "" {
+		verboseF("copying go.work file\n")
+		if err := p.prepareWorkspace(workDir, modCache); err != nil {
+			return false, err
+		}
+	} else if len(p.ModFile) > 0 || len(p.ModuleDeps) > 0 {
+		p.addModuleDeps(workDir, modCache)
+		return p.downloadModFiles(workDir, modCache)
+	} else {
+		verboseF("processing modules\n")
+		goVersion := p.tempGoVersion()
+		verboseF("go version of temporary go.mod: %v\n", color.BlueString(goVersion))
+		if err := os.WriteFile(filepath.Join(workDir, "go.mod"), []byte(TempGoMod(goVersion)), 0664); err != nil {
+			return false, fmt.Errorf("failed to write go.mod file: %w", err)
+		}
+
+		prog := newProgress(len(p.Module))
+		for _, m := range p.Module {
+			start := time.Now()
+			if resolved, err := p.resolveModuleQuery(workDir, modCache, m); err != nil {
+				log.Printf("%v failed to resolve module: %v\n", prog.step(time.Since(start)), color.RedString(m))
+				verboseF("%v: \n%v\n", color.RedString("error"), err)
+				p.addFailure(m, err.Error())
+				continue
+			} else if resolved != m {
+				log.Printf("resolved module %v to %v\n", color.BlueString(m), color.GreenString(resolved))
+				m = resolved
+			}
+
+			// go get would download the module source, so only add the requirement
+			getArgs := []string{"get", m}
+			if p.MetadataOnly || p.NoDownload || p.DryRun {
+				getArgs = []string{"mod", "edit", "-require=" + m}
+			}
+
+			verboseF("adding module: %v\n", color.BlueString(m))
+			if _, err := p.runGoRetried(workDir, modCache, getArgs...); err != nil {
+				log.Printf("failed to add module: %v\n", color.RedString(m))
+				verboseF("%v: \n%v\n", color.RedString("error"), err)
+				p.addFailure(m, err.Error())
+			} else {
+				p.requested = append(p.requested, m)
+			}
+			log.Println(prog.step(time.Since(start)), "added module:", color.BlueString(m))
+		}
+
+	}
+
+	if done, err := p.fetchModules(workDir, modCache); err != nil || done {
+		return done, err
+	}
+	p.checkRequested(modCache)
+	if len(p.CoverGoVersions) > 0 {
+		removeToolchainModules(modCache)
+	}
+	return false, nil
+}
+
+// tempGoVersion returns the go version of the temporary go.mod for the -m modules. The version of
+// the go binary is used by default, so the module graph is pruned like in current modules (go 1.17
+// and newer), but not newer than the oldest --cover-go-versions toolchain, which would refuse it.
+func (p *packer) tempGoVersion() string {
+	if p.GoVersion != "" {
+		return p.GoVersion
+	}
+
+	installed, err := installedGoVersion(p.Go)
+	if err != nil {
+		verboseF("can't determine go version: %v\n", err)
+		return DefaultGoVersion
+	}
+	version := languageVersion(installed)
+	for _, v := range p.CoverGoVersions {
+		if v = languageVersion(strings.TrimPrefix(strings.TrimSpace(v), "go")); compareGoVersions(v, version) < 0 {
+			version = v
+		}
+	}
+	return version
+}
+
+// downloadModFiles downloads the dependencies of every --go-mod-file into modCache. The go.mod
+// files are processed one after another in workDir/mod/<n>, so different versions of a module
+// required by different files are all packed. These version conflicts are logged.
+func (p *packer) downloadModFiles(workDir, modCache string) (done bool, err error) {
+	versions := map[string]map[string][]string{}
+	for i, file := range p.ModFile {
+		dir := workDir
+		if len(p.ModFile) > 1 {
+			dir = filepath.Join(workDir, "mod", fmt.Sprint(i))
+			log.Println("processing go.mod file", color.BlueString(file))
+		}
+
+		verboseF("copying go.mod file\n")
+		if err := copyModFile(file, dir); err != nil {
+			return false, fmt.Errorf("failed to copy go.mod file: %w", err)
+		}
+		if err := p.rewriteReplaces(filepath.Dir(file), dir, modCache); err != nil {
+			return false, fmt.Errorf("failed to rewrite replace directives: %w", err)
+		}
+
+		p.modFile = file
+		if done, err = p.fetchModules(dir, modCache); err != nil {
+			return false, err
+		}
+		if done || len(p.ModFile) < 2 {
+			continue
+		}
+
+		mods, err := p.listModules(dir, modCache)
+		if err != nil {
+			log.Println("failed to list modules:", color.RedString(err.Error()))
+			continue
+		}
+		for _, m := range mods {
+			pkg, ok := SplitModuleVersion(m)
+			if !ok {
+				continue
+			}
+			if versions[pkg[0]] == nil {
+				versions[pkg[0]] = map[string][]string{}
+			}
+			versions[pkg[0]][pkg[1]] = append(versions[pkg[0]][pkg[1]], file)
+		}
+	}
+	p.modFile = ""
+	logVersionConflicts(versions)
+
+	if done {
+		return true, nil
+	}
+	if len(p.CoverGoVersions) > 0 {
+		removeToolchainModules(modCache)
+	}
+	return false, nil
+}
+
+// addModuleDeps downloads the --module-deps modules and adds their go.mod files to the go.mod
+// files to process, so their dependencies are resolved like the ones of -g. The go.mod file of the
+// extracted module is used, so its packages can be listed, or the one of the download cache for
+// modules without go.mod file.
+func (p *packer) addModuleDeps(workDir, modCache string) {
+	for _, m := range p.ModuleDeps {
+		if !strings.Contains(m, "@") {
+			m += "@latest"
+		}
+
+		log.Println("downloading module", color.BlueString(m))
+		mods, err := p.downloadRetried(workDir, modCache, []string{"mod", "download", "-json", m})
+		if err == nil && len(mods) == 0 {
+			err = errors.New("module not reported by go mod download")
+		} else if err == nil && mods[0].Error != "" {
+			err = errors.New(mods[0].Error)
+		}
+		if err != nil {
+			log.Printf("failed to download module: %v\n", color.RedString(m))
+			verboseF("%v: \n%v\n", color.RedString("error"), err)
+			p.addFailure(m, err.Error())
+			continue
+		}
+		p.recordDownload(mods)
+
+		goMod := filepath.Join(mods[0].Dir, "go.mod")
+		if mods[0].Dir == "" || !fileExists(goMod) {
+			goMod = mods[0].GoMod
+		}
+		verboseF("using go.mod file of %v: %v\n", color.BlueString(m), goMod)
+		p.ModFile = append(p.ModFile, goMod)
+	}
+}
+
+// copyModFile copies the go.mod file into dir.
+func copyModFile(file, dir string) error {
+	modContent, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0774); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "go.mod"), modContent, 0664)
+}
+
+// logVersionConflicts logs the modules required in different versions by the go.mod files,
+// versions maps a module path to its versions and the go.mod files requiring them.
+func logVersionConflicts(versions map[string]map[string][]string) {
+	paths := make([]string, 0, len(versions))
+	for path, vs := range versions {
+		if len(vs) > 1 {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		vs := make([]string, 0, len(versions[path]))
+		for v, files := range versions[path] {
+			vs = append(vs, fmt.Sprintf("%v (%v)", v, strings.Join(files, ", ")))
+		}
+		sort.Strings(vs)
+		log.Printf("packing multiple versions of module %v: %v\n", color.YellowString(path), strings.Join(vs, ", "))
+	}
+}
+
+// fetchModules downloads the modules of the go.mod or go.work file in workDir into modCache.
+// It reports true if the command is done, because only the resolved modules were checked or printed.
+func (p *packer) fetchModules(workDir, modCache string) (done bool, err error) {
+	if p.DryRun {
+		if err := p.dryRun(workDir, modCache); err != nil {
+			logErrorHint(err)
+			return false, err
+		}
+		return true, nil
+	}
+
+	if p.NoDownload {
+		if err := p.checkAvailability(workDir, modCache); err != nil {
+			logErrorHint(err)
+			return false, err
+		}
+		return true, nil
+	}
+
+	// go get isn't supported in a workspace, the module graph of all used modules is downloaded instead
+	cmdArgs := []string{"mod", "download", "-json"}
+	if p.DoTransitive || p.Work != "" {
+		cmdArgs = append(cmdArgs, "all")
+	}
+
+	// Resolve with the installed toolchain for the host platform first, followed by
+	// every additional toolchain and platform
+	type resolveRun struct{ toolchain, platform string }
+	runs := []resolveRun{{}}
+	for _, v := range p.CoverGoVersions {
+		runs = append(runs, resolveRun{toolchain: "go" + strings.TrimPrefix(strings.TrimSpace(v), "go")})
+	}
+	for _, pf := range p.Platforms {
+		runs = append(runs, resolveRun{platform: pf})
+	}
+
+	for _, r := range runs {
+		p.toolchain, p.platform = r.toolchain, r.platform
+		if r.toolchain != "" {
+			log.Println("resolving dependencies with toolchain", color.BlueString(r.toolchain))
+		}
+		if r.platform != "" {
+			log.Println("resolving dependencies for platform", color.BlueString(r.platform))
+		}
+
+		args := cmdArgs
+		if p.MetadataOnly {
+			// Loading the module graph fetches the .info and .mod files only
+			args = append(append([]string{"list"}, p.modFlag()...), "-m", "-json", "all")
+		} else if p.NoTestDeps {
+			mods, err := p.listBuildDeps(workDir, modCache, false)
+			if err != nil {
+				logErrorHint(err)
+				return false, fmt.Errorf("failed to list build dependencies: %w", err)
+			}
+			args = append([]string{"mod", "download", "-json"}, p.filterExcluded(mods)...)
+		} else if p.DoTransitive && p.Work == "" {
+			p.addTransitive(workDir, modCache)
+		}
+
+		// Download the modules of the graph explicitly, so the progress can be reported and
+		// the excluded ones are left out, which all would include
+		if (p.DoTransitive || len(p.Exclude) > 0 || p.baseMods != nil) && (p.DoTransitive || p.Work != "") && !p.MetadataOnly && !p.NoTestDeps {
+			mods, err := p.listModules(workDir, modCache)
+			if err != nil {
+				logErrorHint(err)
+				return false, fmt.Errorf("failed to list modules: %w", err)
+			}
+			args = append([]string{"mod", "download", "-json"}, p.filterExcluded(mods)...)
+		}
+
+		if p.MetadataOnly {
+			log.Println("download module metadata")
+		} else {
+			log.Println("download all dependencies")
+		}
+		if err := p.download(workDir, modCache, args...); err != nil {
+			if r == (resolveRun{}) {
+				logErrorHint(err)
+				return false, fmt.Errorf("failed to download dependencies: %w", err)
+			}
+			label := "toolchain " + r.toolchain
+			if r.platform != "" {
+				label = "platform " + r.platform
+			}
+			log.Printf("failed to download dependencies with %v: %v\n", label, color.RedString(err.Error()))
+		}
+
+		if p.TestDeps && !p.MetadataOnly {
+			p.downloadTestDeps(workDir, modCache)
+		}
+	}
+	p.toolchain, p.platform = "", ""
+
+	if p.CacheDir != "" {
+		p.addCacheGraph(workDir, modCache)
+	}
+	if p.Prune {
+		p.addSelected(workDir, modCache)
+	}
+
+	if p.baseMods != nil {
+		if mods, err := p.listModules(workDir, modCache); err == nil {
+			p.warnMissingBase(mods)
+		} else {
+			log.Println("failed to list modules:", color.RedString(err.Error()))
+		}
+	}
+	return false, nil
+}
+
+// publish passes modCache to the Publish option instead of creating an archive. Files for which
+// include reports false are removed from the download cache first.
+func (p *packer) publish(modCache string, include func(string) bool) error {
+	if include != nil {
+		dlDir := filepath.Join(modCache, "cache", "download")
+		err := filepath.Walk(dlDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+
+			if !include(RelSlashPath(modCache, path)) {
+				return os.Remove(path)
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to filter modules: %w", err)
+		}
+	}
+
+	return p.Publish(modCache)
+}
+
+func (p *packer) writeSBOM(modCache string) {
+	output := p.Output
+	if output == "-" {
+		output = "gop_dependencies.zip"
+	}
+
+	mods, err := CollectCacheModules(modCache)
+	if err != nil {
+		log.Println("failed to collect modules for sbom:", color.RedString(err.Error()))
+		return
+	}
+
+	file := sbomFileName(output, p.SBOM)
+	if err := writeSBOM(file, p.SBOM, mods); err != nil {
+		log.Println("failed to write sbom:", color.RedString(err.Error()))
+		return
+	}
+	log.Println("sbom written:", color.GreenString(file))
+}
+
+// createSplitArchives creates an archive for every module with its exclusive dependencies and
+// a shared archive with the dependencies required by several modules. Files for which filter
+// reports false are left out of all archives, a nil filter includes all files.
+func (p *packer) createSplitArchives(workDir, modCache string, filter func(string) bool) error {
+	output, err := RunGoCommand(p.goCommand(workDir, modCache, "mod", "graph"))
+	if err != nil {
+		return fmt.Errorf("failed to get module graph: %w", err)
+	}
+
+	var tops []string
+	for _, m := range p.Module {
+		tops = append(tops, strings.Split(m, "@")[0])
+	}
+
+	split := newModuleSplit(output, "go-offline-packager", tops)
+	for _, archive := range append(tops, sharedArchive) {
+		dst := splitArchiveName(p.Output, archive)
+		log.Println("creating archive:", color.BlueString(dst))
+		include := split.include(archive)
+		if filter != nil {
+			splitInclude := include
+			include = func(relPath string) bool { return splitInclude(relPath) && filter(relPath) }
+		}
+		opts := zipOptions{Include: include, IgnoreErrors: p.IgnoreErrors, Compression: p.Compression,
+			Reproducible: p.Reproducible, VolumeSize: int64(p.SplitSize)}
+		if err := createZipArchive(modCache, dst, opts); err != nil {
+			return fmt.Errorf("failed to create zip archive with dependencies: %w", err)
+		}
+		log.Println("archive created:", color.GreenString(dst))
+		if err := p.writeSum(dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveFilter returns the include func for createZipArchive which skips excluded modules, versions
+// of the base archive and versions published before --newer-than, or nil if all files are included.
+func (p *packer) archiveFilter(modCache string) (func(string) bool, error) {
+	newer, err := p.newerThanFilter(modCache)
+	if err != nil || newer == nil && len(p.Exclude) == 0 && p.baseMods == nil {
+		return nil, err
+	}
+
+	return func(relPath string) bool {
+		if modPath, version := ModuleOfCachePath(relPath); modPath != "" && (p.isExcluded(modPath) || p.inBase(modPath+"@"+version)) {
+			return false
+		}
+		return newer == nil || newer(relPath)
+	}, nil
+}
+
+// isExcluded reports whether the module path matches an --exclude value. Values with a trailing
+// slash match all modules below the prefix, others only the module with exactly this path.
+func (p *packer) isExcluded(modPath string) bool {
+	modPath = strings.Split(modPath, "@")[0]
+	for _, e := range p.Exclude {
+		e = strings.TrimSpace(e)
+		if e == "" {
+			continue
+		}
+		if strings.HasSuffix(e, "/") && strings.HasPrefix(modPath, e) || modPath == e {
+			return true
+		}
+	}
+	return false
+}
+
+// filterExcluded returns mods without the excluded modules and the module versions of the base archive.
+func (p *packer) filterExcluded(mods []string) []string {
+	var filtered []string
+	for _, m := range mods {
+		if p.isExcluded(m) {
+			verboseF("excluding module: %v\n", color.YellowString(m))
+			continue
+		}
+		if p.inBase(m) {
+			verboseF("skipping module of base archive: %v\n", color.YellowString(m))
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+	return filtered
+}
+
+// listModules returns the module@version of all modules of the module graph except the main module.
+func (p *packer) listModules(workDir, modCache string) ([]string, error) {
+	args := append(append([]string{"list"}, p.modFlag()...), "-m", "-f", "{{if not .Main}}{{.Path}}@{{.Version}}{{end}}", "all")
+	output, err := RunGoCommand(p.goCommand(workDir, modCache, args...))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(output)), nil
+}
+
+// newerThanFilter returns the include func for createZipArchive which skips the module versions
+// published before --newer-than, or nil if the option isn't set. The publish time is taken from
+// the .info file of a version, versions without a time are always included.
+func (p *packer) newerThanFilter(modCache string) (func(string) bool, error) {
+	since := time.Time(p.NewerThan)
+	if since.IsZero() {
+		return nil, nil
+	}
+
+	old := map[string]struct{}{}
+	dlDir := filepath.Join(modCache, "cache", "download")
+	err := filepath.Walk(dlDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".info" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var vInfo struct{ Time time.Time }
+		if err := json.Unmarshal(data, &vInfo); err != nil {
+			verboseF("skipping invalid info file %v: %v\n", color.YellowString(path), err)
+			return nil
+		}
+
+		if !vInfo.Time.IsZero() && !vInfo.Time.After(since) {
+			relPath, _ := filepath.Rel(modCache, path)
+			modPath, version := ModuleOfCachePath(filepath.ToSlash(relPath))
+			old[modPath+"@"+version] = struct{}{}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read module versions: %w", err)
+	}
+
+	log.Printf("skipping %v module version(s) published before %v\n", len(old), since.Format(time.RFC3339))
+	return func(relPath string) bool {
+		modPath, version := ModuleOfCachePath(relPath)
+		_, skip := old[modPath+"@"+version]
+		return version == "" || !skip
+	}, nil
+}
+
+// maxTransitiveRounds limits the rounds of adding transitive modules, in case the module graph doesn't converge.
+const maxTransitiveRounds = 100
+
+func (p *packer) addTransitive(workDir, modCache string) {
+	hasMore := false
+	modSet := map[string]struct{}{}
+
+	for round := 1; ; round++ {
+		if round > maxTransitiveRounds {
+			log.Printf("%v module graph didn't converge after %v rounds of adding transitive modules, continuing with the modules added so far\n",
+				errorRedPrefix, maxTransitiveRounds)
+			return
+		}
+
+		output, err := RunGoCommand(p.goCommand(workDir, modCache, "mod", "graph"))
+		if err != nil {
+			log.Println("failed to add transitive dependencies:", color.RedString(err.Error()))
+			return
+		}
+
+		p.addGraphEdges(output)
+		deps := strings.Split(string(output), "\n")
+		if len(deps) == 0 {
+			return
+		}
+
+		var newMods []string
+		for _, dep := range deps {
+			mods := strings.Split(dep, " ")
+			mod := strings.Trim(mods[len(mods)-1], " ")
+
+			if _, exists := modSet[mod]; exists || mod == "" || folderExists(filepath.Join(modCache, EscapePath(mod))) {
+				continue
+			}
+			// go.mod files with a go or toolchain directive have synthetic go@ and toolchain@ edges
+			if strings.HasPrefix(mod, "go@") || strings.HasPrefix(mod, "toolchain@") {
+				continue
+			}
+			if p.isExcluded(mod) {
+				modSet[mod] = struct{}{}
+				verboseF("excluding transitive module: %v\n", color.YellowString(mod))
+				continue
+			}
+
+			modSet[mod] = struct{}{}
+			newMods = append(newMods, mod)
+		}
+
+		batches := batchModules(newMods, p.BatchSize)
+		prog := newProgress(len(batches))
+		for _, batch := range batches {
+			start := time.Now()
+			p.getModules(workDir, modCache, batch)
+			log.Printf("%v added %v transitive module(s)\n", prog.step(time.Since(start)), len(batch))
+			hasMore = true
+		}
+
+		if hasMore {
+			hasMore = false
+			continue
+		}
+		break
+	}
+
+}
+
+// getModules adds the modules with a single go get. As go get doesn't change go.mod if any module
+// fails, a failed batch is retried module by module to find and record the failed modules.
+func (p *packer) getModules(workDir, modCache string, mods []string) {
+	verboseF("adding transitive modules: %v\n", color.BlueString(strings.Join(mods, " ")))
+	args := append([]string{"get"}, mods...)
+	var err error
+	if len(mods) == 1 {
+		// Network errors of a batch are retried module by module
+		_, err = p.runGoRetried(workDir, modCache, args...)
+	} else {
+		_, err = RunGoCommand(p.goCommand(workDir, modCache, args...))
+	}
+	if err == nil {
+		return
+	}
+
+	if len(mods) > 1 {
+		verboseF("failed to add batch of %v modules, retrying module by module\n", len(mods))
+		for _, mod := range mods {
+			p.getModules(workDir, modCache, []string{mod})
+		}
+		return
+	}
+
+	log.Printf("failed to add module: %v\n", color.RedString(mods[0]))
+	verboseF("%v: \n%v\n", color.RedString("error"), err)
+	p.addFailure(mods[0], err.Error())
+}
+
+// batchModules splits mods into batches of at most size modules, a size below 1 is handled as 1.
+func batchModules(mods []string, size int) [][]string {
+	if size < 1 {
+		size = 1
+	}
+
+	var batches [][]string
+	for len(mods) > size {
+		batches = append(batches, mods[:size])
+		mods = mods[size:]
+	}
+	if len(mods) > 0 {
+		batches = append(batches, mods)
+	}
+	return batches
+}
+
+func (p *packer) addFailure(mod, err string) {
+	p.failures = append(p.failures, ModuleError{Module: mod, Err: err})
+}
+
+// DefaultJobs is used if --jobs is less than 1.
+const DefaultJobs = 8
+
+// jobs returns the number of concurrent go commands.
+func (p *packer) jobs() int {
+	if p.Jobs < 1 {
+		return DefaultJobs
+	}
+	return p.Jobs
+}
+
+// downloadChunksPerJob is the number of go commands an explicit module list is split into
+// per job, so the progress can be reported while downloading.
+const downloadChunksPerJob = 10
+
+// download runs the download command and records the downloaded modules. An explicit list
+// of modules is split into chunks downloaded by --jobs concurrent go commands, the module
+// cache is safe for concurrent use. With -json the output is a stream of modules, which may
+// contain modules failed to download.
+func (p *packer) download(workDir, modCache string, args ...string) error {
+	cmdArgs := [][]string{args}
+	var disp *progressDisplay
+	if n := len(args) - 3; n > 1 && args[0] == "mod" && args[1] == "download" && args[3] != "all" {
+		cmdArgs = nil
+		chunks := p.jobs() * downloadChunksPerJob
+		for _, batch := range batchModules(args[3:], (n+chunks-1)/chunks) {
+			cmdArgs = append(cmdArgs, append(append([]string{}, args[:3]...), batch...))
+		}
+		disp = newProgressDisplay(n, "modules downloaded")
+	}
+
+	results := make([][]Module, len(cmdArgs))
+	errs := make([]error, len(cmdArgs))
+	var wg sync.WaitGroup
+	workCh := make(chan int)
+	for w := 0; w < p.jobs() && w < len(cmdArgs); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range workCh {
+				results[i], errs[i] = p.downloadRetried(workDir, modCache, cmdArgs[i])
+				if disp != nil {
+					disp.add(len(cmdArgs[i]) - 3)
+				}
+			}
+		}()
+	}
+	for i := range cmdArgs {
+		workCh <- i
+	}
+	close(workCh)
+	wg.Wait()
+
+	for i := range cmdArgs {
+		p.recordDownload(results[i])
+		if errs[i] != nil {
+			return errs[i]
+		}
+	}
+	return nil
+}
+
+// downloadRetried runs the download command and returns the reported modules. Modules failed
+// with a network error are downloaded again up to --retries times with exponential backoff,
+// as well as the whole command if it failed with a network error without reporting a module.
+func (p *packer) downloadRetried(workDir, modCache string, args []string) ([]Module, error) {
+	var mods []Module
+	for attempt := 0; ; attempt++ {
+		output, cmdErr := RunGoCommand(p.goCommand(workDir, modCache, args...))
+		// Only stdout is parsed, as go writes warnings to stderr which would corrupt the json
+		attemptMods, err := decodeModules(bytes.NewReader(output))
+		if err != nil && cmdErr == nil {
+			return mods, fmt.Errorf("failed to parse download output: %w", err)
+		}
+
+		canRetry := attempt < p.Retries
+		var retry, retryErrs []string
+		failed := 0
+		for _, m := range attemptMods {
+			if m.Error == "" {
+				continue
+			}
+			failed++
+			if canRetry && args[0] == "mod" && classifyFailure(m.Error) == networkFailure {
+				retry = append(retry, m.Path+"@"+m.Version)
+				retryErrs = append(retryErrs, m.Error)
+			}
+		}
+
+		// go exits with an error if a module failed, which is reported by the module
+		if cmdErr != nil && failed == 0 {
+			if !canRetry || classifyFailure(cmdErr.Error()) != networkFailure {
+				return append(mods, attemptMods...), cmdErr
+			}
+			p.waitRetry(attempt, strings.Join(args, " "), cmdErr)
+			continue
+		}
+
+		for _, m := range attemptMods {
+			if m.Error == "" || !canRetry || args[0] != "mod" || classifyFailure(m.Error) != networkFailure {
+				mods = append(mods, m)
+			}
+		}
+		if len(retry) == 0 {
+			return mods, nil
+		}
+		p.waitRetry(attempt, "download of "+strings.Join(retry, ", "), errors.New(strings.Join(retryErrs, "\n")))
+		args = append(append([]string{}, args[:3]...), retry...)
+	}
+}
+
+// runGoRetried runs the go command and retries it up to --retries times with exponential
+// backoff if it fails with a network error.
+func (p *packer) runGoRetried(workDir, modCache string, args ...string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		output, err := RunGoCommand(p.goCommand(workDir, modCache, args...))
+		if err == nil || attempt >= p.Retries || classifyFailure(err.Error()) != networkFailure {
+			return output, err
+		}
+		p.waitRetry(attempt, "go "+strings.Join(args, " "), err)
+	}
+}
+
+// waitRetry logs the retry of what and waits for the backoff of the attempt (1s, 2s, 4s, ...).
+func (p *packer) waitRetry(attempt int, what string, err error) {
+	delay := time.Second << uint(attempt)
+	log.Printf("%v %v failed with a network error, retry %v/%v in %v\n", color.YellowString("warning:"), what, attempt+1, p.Retries, delay)
+	verboseF("%v: %v\n", color.RedString("error"), err)
+	time.Sleep(delay)
+}
+
+// recordDownload records the modules reported by a download command.
+func (p *packer) recordDownload(mods []Module) {
+	for _, m := range mods {
+		if m.Version == "" {
+			// The main module listed by go list -m
+			continue
+		}
+
+		if m.Error != "" {
+			log.Printf("failed to download module: %v\n", color.RedString("%v@%v", m.Path, m.Version))
+			verboseF("%v: %v\n", color.RedString("error"), m.Error)
+			p.addFailure(m.Path+"@"+m.Version, m.Error)
+			continue
+		}
+		verboseF("downloaded module: %v\n", color.BlueString("%v@%v", m.Path, m.Version))
+		if m.Origin != nil {
+			verboseF("  origin: %v\n", RedactURLs(m.Origin.String()))
+		}
+	}
+	p.downloaded = append(p.downloaded, mods...)
+}
+
+// downloadTestDeps downloads the modules providing the packages imported by the tests of the
+// packages to pack. Module graph pruning leaves out the test dependencies of dependencies,
+// so they may be missing from the module graph.
+func (p *packer) downloadTestDeps(workDir, modCache string) {
+	mods, err := p.listBuildDeps(workDir, modCache, true)
+	if err != nil {
+		logErrorHint(err)
+		log.Println("failed to list test dependencies:", color.RedString(err.Error()))
+		return
+	}
+
+	log.Println("download test dependencies")
+	if err := p.download(workDir, modCache, append([]string{"mod", "download", "-json"}, p.filterExcluded(mods)...)...); err != nil {
+		log.Println("failed to download test dependencies:", color.RedString(err.Error()))
+	}
+}
+
+// listBuildDeps returns the module@version of all modules providing packages imported
+// by the packages to pack, test imports are only followed if test is set. For a go.mod
+// file the packages of its module are used, which requires its source next to the go.mod file.
+func (p *packer) listBuildDeps(workDir, modCache string, test bool) ([]string, error) {
+	dir, patterns := workDir, []string{}
+	if p.modFile != "" {
+		dir, patterns = filepath.Dir(p.modFile), []string{"./..."}
+	} else {
+		for _, m := range p.Module {
+			patterns = append(patterns, strings.Split(m, "@")[0]+"/...")
+		}
+	}
+
+	args := []string{"list", "-deps", "-test=false"}
+	if test {
+		args = []string{"list", "-deps", "-test"}
+		// Test imports of the -m modules missing from the pruned module graph are added to the
+		// temporary go.mod, the go.mod file of -g is never modified
+		if p.modFile == "" {
+			args = append(args, p.modFlag()...)
+		}
+	}
+	output, err := RunGoCommand(p.goCommand(dir, modCache, append(append(args,
+		"-f", "{{with .Module}}{{if not .Main}}{{.Path}}@{{.Version}}{{end}}{{end}}"), patterns...)...))
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []string
+	modSet := map[string]struct{}{}
+	for _, m := range strings.Fields(string(output)) {
+		if _, exists := modSet[m]; exists || strings.HasSuffix(m, "@") {
+			continue
+		}
+		modSet[m] = struct{}{}
+		verboseF("adding build dependency: %v\n", color.BlueString(m))
+		mods = append(mods, m)
+	}
+
+	return mods, nil
+}
+
+// addGraphEdges adds the edges of the go mod graph output to the collected module graph. Edges
+// from or to excluded modules and the go and toolchain pseudo modules are left out, so the graph
+// matches the archive.
+func (p *packer) addGraphEdges(output []byte) {
+	if p.GraphJSON == "" {
+		return
+	}
+
+	if p.graphSet == nil {
+		p.graphSet = map[graphEdge]struct{}{}
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		mods := strings.Fields(line)
+		if len(mods) != 2 {
+			continue
+		}
+
+		if p.skipGraphNode(mods[0]) || p.skipGraphNode(mods[1]) {
+			continue
+		}
+
+		edge := graphEdge{From: mods[0], To: mods[1]}
+		if _, exists := p.graphSet[edge]; exists {
+			continue
+		}
+		p.graphSet[edge] = struct{}{}
+		p.graph = append(p.graph, edge)
+	}
+}
+
+// skipGraphNode reports whether the module@version node isn't part of the archive.
+func (p *packer) skipGraphNode(node string) bool {
+	return strings.HasPrefix(node, "go@") || strings.HasPrefix(node, "toolchain@") || p.isExcluded(node)
+}
+
+func (p *packer) writeGraph() error {
+	edges := p.graph
+	if edges == nil {
+		edges = []graphEdge{}
+	}
+
+	data, err := json.MarshalIndent(edges, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeDataFile(p.GraphJSON, append(data, '\n'))
+}
+
+// expandStdinModules replaces a - in mods with the modules read from stdin.
+func expandStdinModules(mods []string) ([]string, error) {
+	var expanded []string
+	for _, m := range mods {
+		if m != "-" {
+			expanded = append(expanded, m)
+			continue
+		}
+
+		stdinMods, err := readModuleList(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		verboseF("read %v modules from stdin\n", len(stdinMods))
+		expanded = append(expanded, stdinMods...)
+	}
+	return expanded, nil
+}
+
+// readModuleList reads one module (path@version) per line, empty lines and
+// comments starting with # are ignored.
+func readModuleList(r io.Reader) ([]string, error) {
+	var mods []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			mods = append(mods, line)
+		}
+	}
+	return mods, scanner.Err()
+}
+
+// modulesFromBinary returns the module@version of all dependencies
+// recorded in the build info of a compiled go binary.
+func modulesFromBinary(file string) ([]string, error) {
+	bi, err := buildinfo.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var mods []string
+	for _, dep := range bi.Deps {
+		if dep.Replace != nil {
+			if dep.Replace.Version == "" {
+				log.Printf("%v skipping module %v replaced by local path %v\n", color.YellowString("warning:"), dep.Path, dep.Replace.Path)
+				continue
+			}
+			dep = dep.Replace
+		}
+
+		verboseF("found module in binary: %v\n", color.BlueString("%v@%v", dep.Path, dep.Version))
+		mods = append(mods, dep.Path+"@"+dep.Version)
+	}
+
+	if bi.Main.Path != "" && semverRegex.MatchString(bi.Main.Version) {
+		mods = append(mods, bi.Main.Path+"@"+bi.Main.Version)
+	}
+
+	return mods, nil
+}
+
+// goCommand returns a go command which runs with the currently selected toolchain. The
+// GOPROXY, NETRC and GOPRIVATE of the options override the environment and the --go-env-file.
+func (p *packer) goCommand(workDir, modCache string, args ...string) *exec.Cmd {
+	cmd := p.Go.Command(workDir, modCache, args...)
+	if p.Proxy != "" {
+		cmd.Env = append(cmd.Env, "GOPROXY="+p.Proxy)
+	}
+	if p.Netrc != "" {
+		cmd.Env = append(cmd.Env, "NETRC="+p.Netrc)
+	}
+	if len(p.Private) > 0 {
+		cmd.Env = append(cmd.Env, "GOPRIVATE="+strings.Join(p.Private, ","))
+	}
+	if p.toolchain != "" {
+		cmd.Env = append(cmd.Env, "GOTOOLCHAIN="+p.toolchain)
+	}
+	if goos, goarch, ok := splitPlatform(p.platform); ok {
+		cmd.Env = append(cmd.Env, "GOOS="+goos, "GOARCH="+goarch)
+	}
+	if p.gopath != "" {
+		cmd.Env = append(cmd.Env, "GOPATH="+p.gopath)
+	}
+	if p.Work != "" {
+		cmd.Env = append(cmd.Env, "GOWORK="+filepath.Join(workDir, "go.work"))
+	}
+	return cmd
+}
+
+// splitPlatform splits a GOOS/GOARCH platform.
+func splitPlatform(platform string) (goos, goarch string, ok bool) {
+	i := strings.Index(platform, "/")
+	if i < 0 {
+		return "", "", false
+	}
+	return strings.TrimSpace(platform[:i]), strings.TrimSpace(platform[i+1:]), true
+}
+
+// modFlag returns the -mod=mod flag to update go.mod while loading the module graph,
+// which isn't allowed in workspace mode.
+func (p *packer) modFlag() []string {
+	if p.Work != "" {
+		return nil
+	}
+	return []string{"-mod=mod"}
+}
+
+// addSelected records the module versions selected in the module graph of workDir for --prune. If
+// they can't be listed, nothing is pruned.
+func (p *packer) addSelected(workDir, modCache string) {
+	mods, err := p.listModules(workDir, modCache)
+	if err != nil {
+		log.Println("failed to list selected modules, not pruning:", color.RedString(err.Error()))
+		p.Prune = false
+		return
+	}
+
+	if p.selected == nil {
+		p.selected = map[string]struct{}{}
+	}
+	for _, m := range mods {
+		p.selected[m] = struct{}{}
+	}
+}
+
+// pruneUnselected removes the .zip and .ziphash files and the extracted sources of the module
+// versions in modCache which aren't selected in the module graph. Their .info and .mod files
+// are kept, as go needs them to load the module graph.
+func (p *packer) pruneUnselected(modCache string) {
+	pruned := map[string]struct{}{}
+	isSelected := func(relPath string) bool {
+		modPath, version := ModuleOfCachePath(relPath)
+		if modPath == "" || version == "" {
+			return true
+		}
+		if _, ok := p.selected[modPath+"@"+version]; ok {
+			return true
+		}
+		pruned[modPath+"@"+version] = struct{}{}
+		return false
+	}
+
+	err := filepath.Walk(modCache, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath := RelSlashPath(modCache, path)
+		if info.IsDir() {
+			// Extracted module sources <module>@<version>
+			if !strings.HasPrefix(relPath, "cache/") && strings.Contains(info.Name(), "@") && !isSelected(relPath) {
+				removeContent(path)
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if ext := filepath.Ext(path); (ext == ".zip" || ext == ".ziphash") && !isSelected(relPath) {
+			_ = os.Chmod(path, 0666)
+			return os.Remove(path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("failed to prune module versions:", color.RedString(err.Error()))
+	}
+
+	for m := range pruned {
+		verboseF("pruned module: %v\n", color.YellowString(m))
+	}
+	log.Printf("pruned %v module versions not selected in the module graph\n", len(pruned))
+}
+
+// sortListFiles sorts the versions of the list files in the download cache of modCache, which
+// go appends in the order the versions were downloaded.
+func sortListFiles(modCache string) error {
+	return filepath.Walk(filepath.Join(modCache, "cache", "download"), func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || info.Name() != "list" {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		versions := strings.Fields(string(data))
+		sort.Strings(versions)
+		content := ""
+		if len(versions) > 0 {
+			content = strings.Join(versions, "\n") + "\n"
+		}
+		return os.WriteFile(path, []byte(content), info.Mode())
+	})
+}
+
+// removeToolchainModules removes the go toolchains downloaded by GOTOOLCHAIN from the
+// module cache, so they don't end up in the archive.
+func removeToolchainModules(modCache string) {
+	dirs, _ := filepath.Glob(filepath.Join(modCache, "golang.org", "toolchain@*"))
+	dirs = append(dirs, filepath.Join(modCache, "cache", "download", "golang.org", "toolchain"))
+	for _, d := range dirs {
+		if folderExists(d) {
+			verboseF("removing toolchain: %v\n", color.BlueString(d))
+			removeContent(d)
+		}
+	}
+}
+
+// semverRegex matches a canonical semantic version as used by go modules.
+var semverRegex = regexp.MustCompile(`^v\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+incompatible)?$`)
+
+// resolveModuleQuery resolves a module query like module@v1, module@>=v1.2.0 or
+// a bare module path to the matching module@version. Modules with an exact
+// version are returned unchanged.
+func (p *packer) resolveModuleQuery(workDir, modCache, m string) (string, error) {
+	path, query := m, "latest"
+	if i := strings.LastIndex(m, "@"); i >= 0 {
+		path, query = m[:i], m[i+1:]
+	}
+
+	if semverRegex.MatchString(query) {
+		return m, nil
+	}
+
+	if strings.HasSuffix(query, ".x") {
+		version, err := p.resolveWildcardVersion(workDir, modCache, path, query)
+		if err != nil {
+			return "", err
+		}
+		return path + "@" + version, nil
+	}
+
+	output, err := RunGoCommand(p.goCommand(workDir, modCache, "list", "-m", "-json", path+"@"+query))
+	if err != nil {
+		return "", err
+	}
+
+	var mod struct {
+		Path    string
+		Version string
+	}
+	if err := json.Unmarshal(output, &mod); err != nil {
+		return "", err
+	}
+	if mod.Version == "" {
+		return "", fmt.Errorf("no version found for query %v", query)
+	}
+
+	return mod.Path + "@" + mod.Version, nil
+}
+
+var releaseRegex = regexp.MustCompile(`^v(\d+)\.(\d+)\.(\d+)(\+incompatible)?$`)
+
+// resolveWildcardVersion returns the highest release version of the module matching
+// a pattern like v1.2.x or v1.x. It requires network access to list the versions.
+func (p *packer) resolveWildcardVersion(workDir, modCache, path, pattern string) (string, error) {
+	output, err := RunGoCommand(p.goCommand(workDir, modCache, "list", "-m", "-versions", "-json", path))
+	if err != nil {
+		return "", fmt.Errorf("failed to list versions: %w", err)
+	}
+
+	var mod struct{ Versions []string }
+	if err := json.Unmarshal(output, &mod); err != nil {
+		return "", err
+	}
+
+	patternParts := strings.Split(strings.TrimPrefix(pattern, "v"), ".")
+	var best string
+	var bestParts [3]int
+	for _, v := range mod.Versions {
+		m := releaseRegex.FindStringSubmatch(v)
+		if m == nil {
+			continue
+		}
+
+		var parts [3]int
+		matches := true
+		for i := 0; i < 3; i++ {
+			parts[i], _ = strconv.Atoi(m[i+1])
+			if i < len(patternParts) && patternParts[i] != "x" && patternParts[i] != m[i+1] {
+				matches = false
+			}
+		}
+
+		if matches && (best == "" || compareVersionParts(parts, bestParts) > 0) {
+			best, bestParts = v, parts
+		}
+	}
+
+	if best == "" {
+		return "", fmt.Errorf("no version found matching %v", pattern)
+	}
+	return best, nil
+}
+
+func compareVersionParts(a, b [3]int) int {
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
+
+// RunGoCommand runs cmd and returns its stdout. The stderr output is kept separate, so
+// diagnostics can't corrupt (json) output. It is logged in verbose mode and added to
+// the returned error.
+func RunGoCommand(cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err := cmd.Run(); err != nil {
+		if stderr.Len() == 0 {
+			return stdout.Bytes(), err
+		}
+		return stdout.Bytes(), fmt.Errorf("%v: %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	if stderr.Len() > 0 {
+		verboseF("%s", stderr.Bytes())
+	}
+	return stdout.Bytes(), nil
+}
+
+func folderExists(name string) bool {
+	if _, err := os.Stat(name); errors.Is(err, os.ErrNotExist) {
+		return false
+	}
+
+	return true
+}
+
+// EscapePath encodes a module path or version like the module cache and the
+// proxy protocol, every upper case letter is replaced by ! and the lower case letter. It is the
+// inverse of UnescapePath.
+func EscapePath(name string) string {
+	name = filepath.ToSlash(name)
+	var b strings.Builder
+	for _, v := range name {
+		if 'A' <= v && v <= 'Z' {
+			b.WriteByte('!')
+			b.WriteRune(v + 'a' - 'A')
+			continue
+		}
+		b.WriteRune(v)
+	}
+	return b.String()
+}
+
+// UnescapePath decodes a module path or version encoded by EscapePath, ex.
+// github.com/!a!w!s/aws-sdk-go to github.com/AWS/aws-sdk-go. A ! which isn't followed by a lower
+// case letter can't be produced by the encoding and is kept.
+func UnescapePath(name string) string {
+	name = filepath.ToSlash(name)
+	var b strings.Builder
+	escaped := false
+	for _, v := range name {
+		if escaped {
+			escaped = false
+			if 'a' <= v && v <= 'z' {
+				b.WriteRune(v - 'a' + 'A')
+				continue
+			}
+			b.WriteByte('!')
+		}
+
+		if v == '!' {
+			escaped = true
+			continue
+		}
+		b.WriteRune(v)
+	}
+	if escaped {
+		b.WriteByte('!')
+	}
+	return b.String()
+}
@@ -0,0 +1,234 @@
+package packager
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEscapePathRoundTrip(t *testing.T) {
+	tests := []struct {
+		path    string
+		escaped string
+	}{
+		{path: "github.com/Foo/Bar/v2", escaped: "github.com/!foo/!bar/v2"},
+		{path: "v2.1.0", escaped: "v2.1.0"},
+		{path: "github.com/jessevdk/go-flags", escaped: "github.com/jessevdk/go-flags"},
+	}
+
+	for _, tt := range tests {
+		if got := EscapePath(tt.path); got != tt.escaped {
+			t.Errorf("EscapePath(%q) = %q, want %q", tt.path, got, tt.escaped)
+		}
+		if got := UnescapePath(tt.escaped); got != tt.path {
+			t.Errorf("UnescapePath(%q) = %q, want %q", tt.escaped, got, tt.path)
+		}
+	}
+
+	mod := "github.com/Foo/Bar/v2@v2.1.0"
+	if got := UnescapePath(EscapePath(mod)); got != mod {
+		t.Errorf("round trip of %q = %q", mod, got)
+	}
+}
+
+func TestEscapePathUpperCase(t *testing.T) {
+	tests := []struct {
+		path    string
+		escaped string
+	}{
+		{path: "Foo", escaped: "!foo"},
+		{path: "AWS", escaped: "!a!w!s"},
+		{path: "github.com/AWS/aws-sdk-go", escaped: "github.com/!a!w!s/aws-sdk-go"},
+		{path: "BurntSushi/toml", escaped: "!burnt!sushi/toml"},
+		{path: "v1.0.0-RC1", escaped: "v1.0.0-!r!c1"},
+	}
+
+	for _, tt := range tests {
+		if got := EscapePath(tt.path); got != tt.escaped {
+			t.Errorf("EscapePath(%q) = %q, want %q", tt.path, got, tt.escaped)
+		}
+		if got := UnescapePath(tt.escaped); got != tt.path {
+			t.Errorf("UnescapePath(%q) = %q, want %q", tt.escaped, got, tt.path)
+		}
+	}
+}
+
+func TestUnescapePathInvalid(t *testing.T) {
+	// A ! which isn't followed by a lower case letter can't be produced by EscapePath and is kept
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "a!", want: "a!"},
+		{name: "a!1", want: "a!1"},
+		{name: "!A", want: "!A"},
+		{name: "a!!b", want: "a!B"},
+	}
+
+	for _, tt := range tests {
+		if got := UnescapePath(tt.name); got != tt.want {
+			t.Errorf("UnescapePath(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+// writeProxyModule writes mod@version with the files to the GOPROXY directory proxyDir.
+func writeProxyModule(t *testing.T, proxyDir, mod, version string, files map[string]string) {
+	t.Helper()
+	dir := filepath.Join(proxyDir, filepath.FromSlash(EscapePath(mod)), "@v")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(mod + "@" + version + "/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for ext, content := range map[string]string{
+		".info": fmt.Sprintf(`{"Version":%q,"Time":"2020-01-01T00:00:00Z"}`, version),
+		".mod":  files["go.mod"],
+		".zip":  buf.String(),
+	} {
+		if err := os.WriteFile(filepath.Join(dir, version+ext), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "list"), []byte(version+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPackNoTestDeps(t *testing.T) {
+	goOpts := testGoOptions(t)
+	proxyDir := t.TempDir()
+	writeProxyModule(t, proxyDir, "example.com/build", "v1.0.0", map[string]string{
+		"go.mod":   "module example.com/build\n\ngo 1.17\n",
+		"build.go": "package build\n\nfunc Build() {}\n",
+	})
+	writeProxyModule(t, proxyDir, "example.com/testonly", "v1.0.0", map[string]string{
+		"go.mod":      "module example.com/testonly\n\ngo 1.17\n",
+		"testonly.go": "package testonly\n\nfunc Assert() {}\n",
+	})
+
+	// The project imports example.com/build in its code and example.com/testonly only in a test
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/project\n\ngo 1.17\n\nrequire (\n\texample.com/build v1.0.0\n\texample.com/testonly v1.0.0\n)\n",
+		"main.go": "package main\n\nimport \"example.com/build\"\n\nfunc main() { build.Build() }\n",
+		"main_test.go": "package main\n\nimport (\n\t\"testing\"\n\n\t\"example.com/testonly\"\n)\n\n" +
+			"func TestMain(t *testing.T) { testonly.Assert() }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// -mod=mod records the hashes of the proxy modules in the go.sum of the project
+	goOpts.Env = append(goOpts.Env, "GOPROXY=file://"+filepath.ToSlash(proxyDir), "GOFLAGS=-mod=mod")
+	captureLog(t)
+	output := filepath.Join(t.TempDir(), "gop_dependencies.zip")
+	if _, err := Pack(PackOptions{
+		ModFile:    []string{filepath.Join(dir, "go.mod")},
+		NoTestDeps: true,
+		Output:     output,
+		Go:         goOpts,
+		TempDir:    t.TempDir(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+
+	var build, testonly bool
+	for _, f := range zr.File {
+		build = build || strings.HasSuffix(f.Name, "example.com/build/@v/v1.0.0.zip")
+		testonly = testonly || strings.Contains(f.Name, "example.com/testonly")
+	}
+	if !build {
+		t.Error("archive doesn't contain the build dependency example.com/build")
+	}
+	if testonly {
+		t.Error("archive contains the test-only dependency example.com/testonly")
+	}
+}
+
+func TestPackGraphJSONExclude(t *testing.T) {
+	goOpts := testGoOptions(t)
+	proxyDir := t.TempDir()
+	writeProxyModule(t, proxyDir, "example.com/build", "v1.0.0", map[string]string{
+		"go.mod":   "module example.com/build\n\ngo 1.17\n\nrequire example.com/internal v1.0.0\n",
+		"build.go": "package build\n\nimport \"example.com/internal\"\n\nfunc Build() { internal.Run() }\n",
+	})
+	writeProxyModule(t, proxyDir, "example.com/internal", "v1.0.0", map[string]string{
+		"go.mod":      "module example.com/internal\n\ngo 1.17\n",
+		"internal.go": "package internal\n\nfunc Run() {}\n",
+	})
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module example.com/project\n\ngo 1.17\n\nrequire (\n\texample.com/build v1.0.0\n\texample.com/internal v1.0.0\n)\n",
+		"main.go": "package main\n\nimport \"example.com/build\"\n\nfunc main() { build.Build() }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	goOpts.Env = append(goOpts.Env, "GOPROXY=file://"+filepath.ToSlash(proxyDir), "GOFLAGS=-mod=mod")
+	captureLog(t)
+	graphF := filepath.Join(t.TempDir(), "graph.json")
+	if _, err := Pack(PackOptions{
+		ModFile:   []string{filepath.Join(dir, "go.mod")},
+		Exclude:   []string{"example.com/internal"},
+		GraphJSON: graphF,
+		Output:    filepath.Join(t.TempDir(), "gop_dependencies.zip"),
+		Go:        goOpts,
+		TempDir:   t.TempDir(),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(graphF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var edges []graphEdge
+	if err := json.Unmarshal(data, &edges); err != nil {
+		t.Fatal(err)
+	}
+
+	var build bool
+	for _, e := range edges {
+		for _, node := range []string{e.From, e.To} {
+			if strings.HasPrefix(node, "example.com/internal") || strings.HasPrefix(node, "go@") || strings.HasPrefix(node, "toolchain@") {
+				t.Errorf("graph contains the edge %v -> %v", e.From, e.To)
+			}
+		}
+		build = build || e.To == "example.com/build@v1.0.0"
+	}
+	if !build {
+		t.Errorf("graph %v doesn't contain example.com/build@v1.0.0", edges)
+	}
+}
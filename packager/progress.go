@@ -0,0 +1,107 @@
+package packager
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// progress tracks the completed items of a known queue and estimates the remaining time.
+type progress struct {
+	total int
+	done  int
+	start time.Time
+	// ewma is the exponentially weighted moving average duration per item.
+	ewma time.Duration
+}
+
+func newProgress(total int) *progress {
+	return &progress{total: total, start: time.Now()}
+}
+
+// step marks an item which took d as completed and returns the progress as [n/total, eta].
+func (p *progress) step(d time.Duration) string {
+	p.done++
+	if p.ewma == 0 {
+		p.ewma = d
+	} else {
+		p.ewma = time.Duration(0.2*float64(d) + 0.8*float64(p.ewma))
+	}
+
+	remaining := p.total - p.done
+	if remaining <= 0 {
+		return fmt.Sprintf("[%v/%v]", p.done, p.total)
+	}
+
+	// Durations of tiny and huge modules vary a lot, so the moving average is
+	// blended with the overall average, which reacts slower to outliers.
+	overall := time.Since(p.start) / time.Duration(p.done)
+	perItem := (overall + p.ewma) / 2
+	eta := (time.Duration(remaining) * perItem).Round(time.Second)
+	return fmt.Sprintf("[%v/%v, eta %v]", p.done, p.total, eta)
+}
+
+// progressLogInterval is the minimum interval between progress lines if the output isn't a terminal.
+const progressLogInterval = 5 * time.Second
+
+// progressDisplay reports the progress of concurrently completed items. On a terminal the
+// progress line is redrawn in place, otherwise (or in verbose mode, which logs every item)
+// a line is logged periodically.
+type progressDisplay struct {
+	mu      sync.Mutex
+	prog    *progress
+	label   string
+	redraw  bool
+	last    time.Time
+	lastLog time.Time
+}
+
+func newProgressDisplay(total int, label string) *progressDisplay {
+	now := time.Now()
+	return &progressDisplay{
+		prog:    newProgress(total),
+		label:   label,
+		redraw:  Verbosef == nil && isTerminal(log.Writer()),
+		last:    now,
+		lastLog: now,
+	}
+}
+
+// add marks n items as completed.
+func (d *progressDisplay) add(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The items complete concurrently, so the time since the last completion is used per item
+	now := time.Now()
+	perItem := now.Sub(d.last) / time.Duration(n)
+	d.last = now
+	var status string
+	for i := 0; i < n; i++ {
+		status = d.prog.step(perItem)
+	}
+
+	switch {
+	case d.redraw:
+		fmt.Fprintf(log.Writer(), "\r%v%v %v\x1b[K", log.Prefix(), status, d.label)
+		if d.prog.done >= d.prog.total {
+			fmt.Fprintln(log.Writer())
+		}
+	case d.prog.done >= d.prog.total || now.Sub(d.lastLog) >= progressLogInterval:
+		d.lastLog = now
+		log.Println(status, d.label)
+	}
+}
+
+// isTerminal reports whether w is a terminal.
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,166 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-sharp/color"
+)
+
+// ignoreAllReplaces is the value of --ignore-replace without module path.
+const ignoreAllReplaces = "all"
+
+// majorSuffixRegex matches the major version suffix of a module path (ex. /v2 or .v3 of gopkg.in).
+var majorSuffixRegex = regexp.MustCompile(`[/.]v([2-9]|[1-9][0-9]+)$`)
+
+// rewriteReplaces removes the replace directives of the go.mod file in dir ignored by --ignore-replace,
+// so the upstream modules are packed. The modules replaced with a local directory are copied into
+// dir/replace/<n> and the replacements point to the copies, as relative directories would point to
+// a wrong location from the copied go.mod file, srcDir is the directory of the original one.
+// Unless only the module graph is checked, the copies are added to modCache with a pseudo-version.
+func (p *packer) rewriteReplaces(srcDir, dir, modCache string) error {
+	output, err := RunGoCommand(p.Go.Command(dir, modCache, "mod", "edit", "-json"))
+	if err != nil {
+		return err
+	}
+	var mod goModFile
+	if err := json.Unmarshal(output, &mod); err != nil {
+		return err
+	}
+
+	args := []string{"mod", "edit"}
+	localized := 0
+	for _, r := range mod.Replace {
+		if p.ignoresReplace(r.Old.Path) {
+			log.Printf("ignoring replace %v => %v\n", color.BlueString(r.old()), strings.TrimSpace(r.New.Path+" "+r.New.Version))
+			args = append(args, "-dropreplace="+r.old())
+			continue
+		}
+		if !r.isLocal() {
+			continue
+		}
+
+		src := localReplacement(r, srcDir)
+		if !fileExists(filepath.Join(src, "go.mod")) {
+			return fmt.Errorf("replacement %v of %v has no go.mod file", r.New.Path, r.old())
+		}
+		files, err := moduleFiles(src, func(name string) bool {
+			switch path.Base(name) {
+			case ".git", ".hg", ".svn", ".bzr":
+				return true
+			}
+			return fileExists(filepath.Join(src, filepath.FromSlash(name), "go.mod"))
+		})
+		if err != nil {
+			return err
+		}
+
+		dstDir := filepath.Join("replace", fmt.Sprint(localized))
+		localized++
+		verboseF("copying local module %v to %v\n", color.BlueString(src), color.BlueString(dstDir))
+		for _, name := range files {
+			if err := copyFile(filepath.Join(src, filepath.FromSlash(name)), filepath.Join(dir, dstDir, filepath.FromSlash(name))); err != nil {
+				return err
+			}
+		}
+		args = append(args, fmt.Sprintf("-replace=%v=./%v", r.old(), filepath.ToSlash(dstDir)))
+
+		if p.DryRun || p.NoDownload {
+			log.Printf("localized replace %v => %v\n", color.BlueString(r.old()), r.New.Path)
+			continue
+		}
+		version, err := localPseudoVersion(r.Old.Path, src, files)
+		if err != nil {
+			return err
+		}
+		if err := p.addLocalModule(modCache, src, r.Old.Path, version, files); err != nil {
+			return err
+		}
+		log.Printf("localized replace %v => %v as %v\n", color.BlueString(r.old()), r.New.Path, color.BlueString(r.Old.Path+"@"+version))
+	}
+
+	if len(args) == 2 {
+		return nil
+	}
+	_, err = RunGoCommand(p.Go.Command(dir, modCache, args...))
+	return err
+}
+
+// ignoresReplace reports whether the replace directive of modPath is removed by --ignore-replace.
+func (p *packer) ignoresReplace(modPath string) bool {
+	for _, m := range p.IgnoreReplace {
+		if m == ignoreAllReplaces || m == modPath {
+			return true
+		}
+	}
+	return false
+}
+
+// addLocalModule adds the files of the local module in src as modPath@version to modCache
+// and records it for the manifest.
+func (p *packer) addLocalModule(modCache, src, modPath, version string, files []string) error {
+	modContent, err := os.ReadFile(filepath.Join(src, "go.mod"))
+	if err != nil {
+		return err
+	}
+	if err := writeCacheModule(modCache, src, modPath, version, files, modContent); err != nil {
+		return fmt.Errorf("%v@%v: %w", modPath, version, err)
+	}
+
+	base := filepath.Join(modCache, "cache", "download", filepath.FromSlash(EscapePath(modPath)), "@v", EscapePath(version))
+	sum, err := HashZip(base + ".zip")
+	if err != nil {
+		return err
+	}
+	goModSum, err := hashGoMod(base + ".mod")
+	if err != nil {
+		return err
+	}
+	p.downloaded = append(p.downloaded, Module{Path: modPath, Version: version, Sum: sum, GoModSum: goModSum})
+	return nil
+}
+
+// localPseudoVersion returns a pseudo-version for the files of the local module in src. The time is
+// the latest modification of the files and the revision a hash of their names and contents, so
+// unchanged sources get the same version.
+func localPseudoVersion(modPath, src string, files []string) (string, error) {
+	var latest time.Time
+	h := sha256.New()
+	for _, name := range files {
+		file := filepath.Join(src, filepath.FromSlash(name))
+		info, err := os.Stat(file)
+		if err != nil {
+			return "", err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+
+		f, err := os.Open(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%v\x00", name)
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	major := "0"
+	if m := majorSuffixRegex.FindStringSubmatch(modPath); m != nil {
+		major = m[1]
+	}
+	return fmt.Sprintf("v%v.0.0-%v-%v", major, latest.UTC().Format("20060102150405"), hex.EncodeToString(h.Sum(nil))[:12]), nil
+}
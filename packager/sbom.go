@@ -0,0 +1,131 @@
+package packager
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	sbomCycloneDX = "cyclonedx-json"
+	sbomSPDX      = "spdx-json"
+)
+
+// sbomFileName returns the file name of the sbom written next to the archive.
+func sbomFileName(output, format string) string {
+	if format == sbomSPDX {
+		return output + ".spdx.json"
+	}
+	return output + ".cdx.json"
+}
+
+// writeSBOM writes a software bill of materials of the modules in the given format.
+func writeSBOM(file, format string, mods []Module) error {
+	var doc interface{}
+	switch format {
+	case sbomCycloneDX:
+		doc = cycloneDXDocument(mods)
+	case sbomSPDX:
+		doc = spdxDocument(file, mods)
+	default:
+		return fmt.Errorf("unknown sbom format: %v", format)
+	}
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(data, '\n'), 0664)
+}
+
+func cycloneDXDocument(mods []Module) map[string]interface{} {
+	var components []map[string]interface{}
+	for _, m := range mods {
+		c := map[string]interface{}{
+			"type":    "library",
+			"bom-ref": modulePURL(m),
+			"name":    m.Path,
+			"version": m.Version,
+			"purl":    modulePURL(m),
+		}
+		if sum := moduleZipSHA256(m); sum != "" {
+			c["hashes"] = []map[string]string{{"alg": "SHA-256", "content": sum}}
+		}
+		if m.Sum != "" {
+			c["properties"] = []map[string]string{{"name": "go:sum", "value": m.Sum}}
+		}
+		components = append(components, c)
+	}
+
+	return map[string]interface{}{
+		"bomFormat":    "CycloneDX",
+		"specVersion":  "1.5",
+		"serialNumber": "urn:uuid:" + newUUID(),
+		"version":      1,
+		"metadata": map[string]interface{}{
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+			"tools":     []map[string]string{{"name": "go-offline-packager", "version": Version}},
+		},
+		"components": components,
+	}
+}
+
+func spdxDocument(file string, mods []Module) map[string]interface{} {
+	var packages []map[string]interface{}
+	for i, m := range mods {
+		p := map[string]interface{}{
+			"name":             m.Path,
+			"SPDXID":           fmt.Sprintf("SPDXRef-Package-%v", i+1),
+			"versionInfo":      m.Version,
+			"downloadLocation": "NOASSERTION",
+			"filesAnalyzed":    false,
+			"externalRefs": []map[string]string{{
+				"referenceCategory": "PACKAGE-MANAGER",
+				"referenceType":     "purl",
+				"referenceLocator":  modulePURL(m),
+			}},
+		}
+		if sum := moduleZipSHA256(m); sum != "" {
+			p["checksums"] = []map[string]string{{"algorithm": "SHA256", "checksumValue": sum}}
+		}
+		packages = append(packages, p)
+	}
+
+	return map[string]interface{}{
+		"spdxVersion":       "SPDX-2.3",
+		"dataLicense":       "CC0-1.0",
+		"SPDXID":            "SPDXRef-DOCUMENT",
+		"name":              file,
+		"documentNamespace": "https://github.com/go-sharp/go-offline-packager/spdx/" + newUUID(),
+		"creationInfo": map[string]interface{}{
+			"created":  time.Now().UTC().Format(time.RFC3339),
+			"creators": []string{"Tool: go-offline-packager-" + Version},
+		},
+		"packages": packages,
+	}
+}
+
+// modulePURL returns the package url of a module (pkg:golang/<path>@<version>).
+func modulePURL(m Module) string {
+	return "pkg:golang/" + m.Path + "@" + strings.ReplaceAll(m.Version, "+", "%2B")
+}
+
+// moduleZipSHA256 returns the sha256 of the module zip, or an empty string if there is none.
+func moduleZipSHA256(m Module) string {
+	if m.Zip == "" {
+		return ""
+	}
+	sum, _ := FileSHA256(m.Zip)
+	return sum
+}
+
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
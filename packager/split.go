@@ -0,0 +1,135 @@
+package packager
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const sharedArchive = "shared"
+
+// ModuleOfCachePath returns the module path and version a file of the module cache belongs to.
+// The version is empty for files belonging to all versions of a module (ex. @v/list) and
+// the path is empty for files not belonging to a module (ex. sumdb).
+func ModuleOfCachePath(relPath string) (path, version string) {
+	if strings.HasPrefix(relPath, "cache/download/") {
+		relPath = strings.TrimPrefix(relPath, "cache/download/")
+		i := strings.Index(relPath, "/@v/")
+		if i < 0 {
+			return "", ""
+		}
+
+		path, file := UnescapePath(relPath[:i]), relPath[i+len("/@v/"):]
+		if ext := filepath.Ext(file); ext == ".info" || ext == ".mod" || ext == ".zip" || ext == ".ziphash" {
+			version = UnescapePath(strings.TrimSuffix(file, ext))
+		}
+		return path, version
+	}
+
+	if strings.HasPrefix(relPath, "cache/") {
+		return "", ""
+	}
+
+	// Extracted module <module>@<version>/...
+	at := strings.Index(relPath, "@")
+	if at < 0 {
+		return "", ""
+	}
+	version = relPath[at+1:]
+	if i := strings.Index(version, "/"); i >= 0 {
+		version = version[:i]
+	}
+	return UnescapePath(relPath[:at]), UnescapePath(version)
+}
+
+// moduleSplit assigns every module version of the cache to the archive of the
+// top-level module requiring it, or to the shared archive if several do.
+type moduleSplit struct {
+	owner      map[string]string
+	pathOwners map[string]map[string]struct{}
+}
+
+// newModuleSplit computes the split from the go mod graph output, tops are the module paths
+// which get their own archive.
+func newModuleSplit(graph []byte, root string, tops []string) *moduleSplit {
+	edges := map[string][]string{}
+	for _, line := range strings.Split(string(graph), "\n") {
+		mods := strings.Fields(line)
+		if len(mods) == 2 {
+			edges[mods[0]] = append(edges[mods[0]], mods[1])
+		}
+	}
+
+	reachedBy := map[string]map[string]struct{}{}
+	for _, top := range tops {
+		var queue []string
+		for _, n := range edges[root] {
+			if strings.Split(n, "@")[0] == top {
+				queue = append(queue, n)
+			}
+		}
+
+		seen := map[string]struct{}{}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			if _, ok := seen[n]; ok {
+				continue
+			}
+			seen[n] = struct{}{}
+
+			if reachedBy[n] == nil {
+				reachedBy[n] = map[string]struct{}{}
+			}
+			reachedBy[n][top] = struct{}{}
+			queue = append(queue, edges[n]...)
+		}
+	}
+
+	s := &moduleSplit{owner: map[string]string{}, pathOwners: map[string]map[string]struct{}{}}
+	for n, tops := range reachedBy {
+		owner := sharedArchive
+		if len(tops) == 1 {
+			for t := range tops {
+				owner = t
+			}
+		}
+		s.owner[n] = owner
+
+		path := strings.Split(n, "@")[0]
+		if s.pathOwners[path] == nil {
+			s.pathOwners[path] = map[string]struct{}{}
+		}
+		s.pathOwners[path][owner] = struct{}{}
+	}
+	return s
+}
+
+// include returns the include func for createZipArchive of the given archive.
+func (s *moduleSplit) include(archive string) func(string) bool {
+	return func(relPath string) bool {
+		path, version := ModuleOfCachePath(relPath)
+		if path == "" {
+			return archive == sharedArchive
+		}
+
+		if version != "" {
+			owner, ok := s.owner[path+"@"+version]
+			if !ok {
+				owner = sharedArchive
+			}
+			return owner == archive
+		}
+
+		// Files for all versions like @v/list go to every archive with a version of the module,
+		// the shared archive gets them anyway as it may contain versions missing in the graph.
+		_, ok := s.pathOwners[path][archive]
+		return ok || archive == sharedArchive
+	}
+}
+
+// splitArchiveName returns the file name of the archive for a module, placed next to output.
+func splitArchiveName(output, archive string) string {
+	name := strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(archive)
+	return filepath.Join(filepath.Dir(output), fmt.Sprintf("gop_%v.zip", name))
+}
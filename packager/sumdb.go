@@ -0,0 +1,41 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/go-sharp/color"
+)
+
+// bundleSumDB makes the checksum database cache of modCache servable by a proxy folder. The go
+// command caches the lookups and tiles in the download cache, but the signed tree head (latest)
+// in GOPATH/pkg/sumdb. The latest files are copied into the download cache and a supported file
+// is written, which tells go to access the checksum database through the proxy.
+func bundleSumDB(modCache, gopath string) error {
+	sumdbDir := filepath.Join(modCache, "cache", "download", "sumdb")
+	latestFiles, err := filepath.Glob(filepath.Join(gopath, "pkg", "sumdb", "*", "latest"))
+	if err != nil {
+		return err
+	}
+
+	for _, latest := range latestFiles {
+		name := filepath.Base(filepath.Dir(latest))
+		dbDir := filepath.Join(sumdbDir, name)
+		if !folderExists(dbDir) {
+			continue
+		}
+
+		data, err := os.ReadFile(latest)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dbDir, "latest"), data, 0664); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dbDir, "supported"), nil, 0664); err != nil {
+			return err
+		}
+		verboseF("bundled checksum database: %v\n", color.BlueString(name))
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sharp/color"
+)
+
+// CreateTempWorkDir creates a temporary working directory in tempDir, the system temp directory
+// if empty. cleanFn removes it with its content.
+func CreateTempWorkDir(tempDir string) (wd string, cleanFn func(), err error) {
+	if tempDir == "" {
+		tempDir = os.TempDir()
+	}
+	dir, err := os.MkdirTemp(tempDir, "gop_")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary working directory: %w", err)
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary working directory: %w", err)
+	}
+	verboseF("working directory: %v\n", color.BlueString(dir))
+
+	return dir, func() { removeContent(dir) }, nil
+}
+
+// removeContent removes dir with its content, files which can't be removed are logged in verbose mode.
+func removeContent(dir string) {
+	defer func() {
+		if err := os.Remove(dir); err != nil {
+			verboseF("can't remove directory: %v\n", err)
+		}
+	}()
+
+	f, err := os.Open(dir)
+	if err != nil {
+		verboseF("can't remove directory %v: %v\n", dir, color.YellowString(err.Error()))
+		return
+	}
+	defer f.Close()
+
+	fs, err := f.Readdirnames(0)
+	if err != nil {
+		verboseF("can't read directory %v: %v\n", dir, color.YellowString(err.Error()))
+		return
+	}
+
+	for _, fi := range fs {
+		fpath := filepath.Join(dir, fi)
+		fstat, err := os.Stat(fpath)
+		if err != nil {
+			verboseF("can't read file stat %v: %v\n", dir, color.YellowString(err.Error()))
+			continue
+		}
+
+		// Handle directory
+		if fstat.IsDir() {
+			_ = os.Chmod(fpath, 0777)
+			removeContent(fpath)
+			continue
+		}
+
+		_ = os.Chmod(fpath, 0666)
+		if err := os.Remove(fpath); err != nil {
+			verboseF("can't remove directory: %v\n", err)
+		}
+	}
+}
+
+// writeDataFile writes data to file, - writes it to stdout. The log goes to stderr, so only
+// the data is piped.
+func writeDataFile(file string, data []byte) error {
+	if file == "-" {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(file, data, 0664)
+}
+
+// dataFileName returns the name of a file written with writeDataFile for the log.
+func dataFileName(file string) string {
+	if file == "-" {
+		return "stdout"
+	}
+	return file
+}
+
+var urlPasswordRegex = regexp.MustCompile(`(://[^/@:\s]*):[^/@\s]*@`)
+
+// RedactURLs replaces the passwords of the urls in s (ex. a GOPROXY list) with xxxxx.
+func RedactURLs(s string) string {
+	return urlPasswordRegex.ReplaceAllString(s, "${1}:xxxxx@")
+}
+
+// ByteSize is a size in bytes which can be specified on the command line
+// with an optional unit suffix (ex. 512K, 10MB, 4GB).
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	factor int64
+}{
+	{"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+	{"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10}, {"B", 1},
+}
+
+// UnmarshalFlag implements the flags.Unmarshaler interface.
+func (b *ByteSize) UnmarshalFlag(value string) error {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	factor := int64(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			factor = u.factor
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid size: %v", value)
+	}
+	*b = ByteSize(n * factor)
+	return nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range byteSizeUnits[:4] {
+		if int64(b) >= u.factor {
+			return fmt.Sprintf("%.1f%v", float64(b)/float64(u.factor), u.suffix)
+		}
+	}
+	return fmt.Sprintf("%dB", int64(b))
+}
+
+// Date is a point in time which is specified as date (2006-01-02) or RFC 3339 time on the command line.
+type Date time.Time
+
+// UnmarshalFlag implements the flags.Unmarshaler interface.
+func (d *Date) UnmarshalFlag(value string) error {
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		if t, err := time.Parse(layout, value); err == nil {
+			*d = Date(t)
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid date: %v", value)
+}
+
+func (d Date) String() string {
+	if time.Time(d).IsZero() {
+		return ""
+	}
+	return time.Time(d).Format(time.RFC3339)
+}
+
+// SplitModuleVersion splits a module directory name like name@v1.2.3 on the last @ into
+// the module name and its version. It reports false if the version isn't a valid
+// semantic version, pseudo-version or +incompatible version.
+func SplitModuleVersion(name string) (pkg [2]string, ok bool) {
+	i := strings.LastIndex(name, "@")
+	if i <= 0 {
+		return pkg, false
+	}
+
+	pkg[0], pkg[1] = name[:i], name[i+1:]
+	return pkg, semverRegex.MatchString(pkg[1])
+}
+
+// RelSlashPath returns path relative to base separated by forward slashes, ex. the path of a
+// module file in the proxy layout below a walked folder.
+func RelSlashPath(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
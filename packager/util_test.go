@@ -0,0 +1,35 @@
+package packager
+
+import "testing"
+
+func TestSplitModuleVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		version string
+		ok      bool
+	}{
+		{name: "github.com/jessevdk/go-flags@v1.4.0", path: "github.com/jessevdk/go-flags", version: "v1.4.0", ok: true},
+		{name: "github.com/docker/docker@v20.10.7+incompatible", path: "github.com/docker/docker", version: "v20.10.7+incompatible", ok: true},
+		{name: "golang.org/x/sys@v0.0.0-20191026070338-33540a1f6037", path: "golang.org/x/sys", version: "v0.0.0-20191026070338-33540a1f6037", ok: true},
+		{name: "example.com/a@v1.2.4-0.20240131120000-4f2c1a9b3d7e", path: "example.com/a", version: "v1.2.4-0.20240131120000-4f2c1a9b3d7e", ok: true},
+		{name: "example.com/a@v2.0.1-0.20240131120000-4f2c1a9b3d7e+incompatible", path: "example.com/a", version: "v2.0.1-0.20240131120000-4f2c1a9b3d7e+incompatible", ok: true},
+		{name: "example.com/a@v1.0.0-rc.1", path: "example.com/a", version: "v1.0.0-rc.1", ok: true},
+		{name: "example.com/a@b@v1.0.0", path: "example.com/a@b", version: "v1.0.0", ok: true},
+		{name: "example.com/a@latest", path: "example.com/a", version: "latest"},
+		{name: "example.com/a@v1.0", path: "example.com/a", version: "v1.0"},
+		{name: "example.com/a@v1.0.0+meta", path: "example.com/a", version: "v1.0.0+meta"},
+		{name: "example.com/a"},
+		{name: "@v1.0.0"},
+	}
+
+	for _, tt := range tests {
+		pkg, ok := SplitModuleVersion(tt.name)
+		if ok != tt.ok {
+			t.Errorf("SplitModuleVersion(%q) reports %v, want %v", tt.name, ok, tt.ok)
+		}
+		if tt.path != "" && (pkg[0] != tt.path || pkg[1] != tt.version) {
+			t.Errorf("SplitModuleVersion(%q) = %q, want [%q %q]", tt.name, pkg, tt.path, tt.version)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package packager
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/archive"
+)
+
+// vendorModule is a module listed in vendor/modules.txt.
+type vendorModule struct {
+	Path      string
+	Version   string
+	GoVersion string
+}
+
+// readModulesTxt returns the modules of a vendor/modules.txt file. Modules replaced by a local
+// directory have no version and are skipped with a warning.
+func readModulesTxt(file string) ([]vendorModule, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var mods []vendorModule
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "## ") && len(mods) > 0:
+			// Annotations of the previous module ex. "## explicit; go 1.21"
+			for _, a := range strings.Split(strings.TrimPrefix(line, "## "), ";") {
+				if a = strings.TrimSpace(a); strings.HasPrefix(a, "go ") {
+					mods[len(mods)-1].GoVersion = strings.TrimPrefix(a, "go ")
+				}
+			}
+		case strings.HasPrefix(line, "# "):
+			fields := strings.Fields(strings.TrimPrefix(line, "# "))
+			if len(fields) < 2 || fields[1] == "=>" {
+				log.Println(color.YellowString("warning:"), "skipping vendored module without version:", strings.TrimPrefix(line, "# "))
+				continue
+			}
+			mods = append(mods, vendorModule{Path: fields[0], Version: fields[1]})
+		}
+	}
+	return mods, scanner.Err()
+}
+
+// createVendorCache writes the download cache layout (.info, .mod, .zip, .ziphash and list) and the
+// extracted sources of the modules vendored in vendorDir into modCache. The vendor directory only
+// contains the packages used by the main module and no go.mod files of the dependencies, so the
+// synthesized files have other hashes than the ones of the module proxy.
+func createVendorCache(vendorDir, modCache string) error {
+	mods, err := readModulesTxt(filepath.Join(vendorDir, "modules.txt"))
+	if err != nil {
+		return err
+	}
+
+	paths := make([]string, 0, len(mods))
+	for _, m := range mods {
+		paths = append(paths, m.Path)
+	}
+
+	for _, m := range mods {
+		src := filepath.Join(vendorDir, filepath.FromSlash(m.Path))
+		if !folderExists(src) {
+			log.Println(color.YellowString("warning:"), "skipping module missing in vendor directory:", m.Path)
+			continue
+		}
+
+		verboseF("adding vendored module: %v\n", color.BlueString(m.Path+"@"+m.Version))
+		if err := writeVendorModule(modCache, src, m, nestedModules(m.Path, paths)); err != nil {
+			return fmt.Errorf("%v@%v: %w", m.Path, m.Version, err)
+		}
+		log.Println("added module:", color.BlueString(m.Path+"@"+m.Version))
+	}
+
+	log.Println(color.YellowString("warning:"), "the hashes of vendored modules differ from go.sum, consumers must not verify them (ex. GONOSUMDB and no go.sum entries)")
+	return nil
+}
+
+// nestedModules returns the paths of the modules which are nested inside of the module modPath.
+func nestedModules(modPath string, paths []string) []string {
+	var nested []string
+	for _, p := range paths {
+		if strings.HasPrefix(p, modPath+"/") {
+			nested = append(nested, p)
+		}
+	}
+	return nested
+}
+
+func writeVendorModule(modCache, src string, m vendorModule, nested []string) error {
+	files, err := moduleFiles(src, func(name string) bool {
+		for _, n := range nested {
+			if path.Join(m.Path, name) == n {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+
+	modContent := "module " + m.Path + "\n"
+	if m.GoVersion != "" {
+		modContent += "\ngo " + m.GoVersion + "\n"
+	}
+	return writeCacheModule(modCache, src, m.Path, m.Version, files, []byte(modContent))
+}
+
+// moduleFiles returns the slash separated paths of the regular files in src, sorted. The
+// directories for which skipDir reports true are left out.
+func moduleFiles(src string, skipDir func(name string) bool) ([]string, error) {
+	var files []string
+	err := filepath.Walk(src, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relPath, err := filepath.Rel(src, file)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(relPath)
+		if info.IsDir() {
+			if name != "." && skipDir(name) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Mode().IsRegular() {
+			files = append(files, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// writeCacheModule writes the download cache layout (.info, .mod, .zip, .ziphash and list) of
+// the module version with the files of src and the go.mod content modContent into modCache.
+func writeCacheModule(modCache, src, modPath, version string, files []string, modContent []byte) error {
+	escPath := EscapePath(modPath)
+	escVersion := EscapePath(version)
+	srcDir := filepath.Join(modCache, filepath.FromSlash(escPath)+"@"+escVersion)
+	dlDir := filepath.Join(modCache, "cache", "download", filepath.FromSlash(escPath), "@v")
+	if err := os.MkdirAll(dlDir, 0774); err != nil {
+		return err
+	}
+
+	zipFile := filepath.Join(dlDir, escVersion+".zip")
+	if err := writeVendorZip(zipFile, src, srcDir, modPath+"@"+version, files); err != nil {
+		return err
+	}
+
+	hash, err := HashZip(zipFile)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dlDir, escVersion+".ziphash"), []byte(hash), 0664); err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(filepath.Join(dlDir, escVersion+".mod"), modContent, 0664); err != nil {
+		return err
+	}
+
+	info, err := json.Marshal(struct{ Version string }{version})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dlDir, escVersion+".info"), info, 0664); err != nil {
+		return err
+	}
+
+	list, err := os.OpenFile(filepath.Join(dlDir, "list"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(list, version); err != nil {
+		list.Close()
+		return err
+	}
+	return list.Close()
+}
+
+// writeVendorZip writes the files of src as module zip with the entry prefix <module>@<version>/
+// and copies them to the extracted module directory srcDir.
+func writeVendorZip(zipFile, src, srcDir, prefix string, files []string) (err error) {
+	f, err := os.Create(zipFile)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	zw := zip.NewWriter(f)
+	for _, name := range files {
+		file := filepath.Join(src, filepath.FromSlash(name))
+		if err := archive.AddFile(zw, file, prefix+"/"+name); err != nil {
+			return err
+		}
+		if err := copyFile(file, filepath.Join(srcDir, filepath.FromSlash(name))); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// copyFile copies src to the new file dst, creating its parent directories.
+func copyFile(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0774); err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,143 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sharp/color"
+)
+
+// goWork is the output of go work edit -json.
+type goWork struct {
+	Go        string
+	Toolchain string
+	Use       []struct{ DiskPath string }
+	Replace   []goReplace
+}
+
+// goModFile is the part of the output of go mod edit -json needed to copy a go.mod file.
+type goModFile struct {
+	Replace []goReplace
+}
+
+type goReplace struct {
+	Old struct{ Path, Version string }
+	New struct{ Path, Version string }
+}
+
+// isLocal reports whether the module is replaced by a directory.
+func (r goReplace) isLocal() bool {
+	return r.New.Version == ""
+}
+
+func (r goReplace) old() string {
+	if r.Old.Version == "" {
+		return r.Old.Path
+	}
+	return r.Old.Path + "@" + r.Old.Version
+}
+
+// prepareWorkspace copies the go.work file and the go.mod files of all used modules into workDir.
+// The modules are placed in workDir/use/<n>, relative paths of local replacements are made absolute,
+// as they would point to a wrong location from the copies.
+func (p *packer) prepareWorkspace(workDir, modCache string) error {
+	workFile, err := filepath.Abs(p.Work)
+	if err != nil {
+		return err
+	}
+	workRoot := filepath.Dir(workFile)
+
+	output, err := RunGoCommand(p.Go.Command(workRoot, modCache, "work", "edit", "-json", workFile))
+	if err != nil {
+		return fmt.Errorf("failed to read go.work file: %w", err)
+	}
+	var work goWork
+	if err := json.Unmarshal(output, &work); err != nil {
+		return fmt.Errorf("failed to parse go.work file: %w", err)
+	}
+
+	var b strings.Builder
+	if work.Go != "" {
+		fmt.Fprintf(&b, "go %v\n", work.Go)
+	}
+	if work.Toolchain != "" {
+		fmt.Fprintf(&b, "toolchain %v\n", work.Toolchain)
+	}
+
+	for i, use := range work.Use {
+		srcDir := use.DiskPath
+		if !filepath.IsAbs(srcDir) {
+			srcDir = filepath.Join(workRoot, filepath.FromSlash(srcDir))
+		}
+		if !fileExists(filepath.Join(srcDir, "go.mod")) {
+			return fmt.Errorf("used directory %v of go.work has no go.mod file", use.DiskPath)
+		}
+
+		dstDir := filepath.Join("use", fmt.Sprint(i))
+		verboseF("copying go.mod of %v to %v\n", color.BlueString(use.DiskPath), color.BlueString(dstDir))
+		if err := p.copyGoMod(srcDir, filepath.Join(workDir, dstDir), modCache); err != nil {
+			return fmt.Errorf("failed to copy go.mod of %v: %w", use.DiskPath, err)
+		}
+		fmt.Fprintf(&b, "use ./%v\n", filepath.ToSlash(dstDir))
+	}
+
+	for _, r := range work.Replace {
+		fmt.Fprintf(&b, "replace %v => %v\n", r.old(), localReplacement(r, workRoot))
+	}
+
+	return os.WriteFile(filepath.Join(workDir, "go.work"), []byte(b.String()), 0664)
+}
+
+// copyGoMod copies the go.mod and go.sum file of the module in srcDir into dstDir.
+func (p *packer) copyGoMod(srcDir, dstDir, modCache string) error {
+	if err := os.MkdirAll(dstDir, 0774); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"go.mod", "go.sum"} {
+		content, err := os.ReadFile(filepath.Join(srcDir, name))
+		if os.IsNotExist(err) && name == "go.sum" {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(dstDir, name), content, 0664); err != nil {
+			return err
+		}
+	}
+
+	output, err := RunGoCommand(p.Go.Command(dstDir, modCache, "mod", "edit", "-json"))
+	if err != nil {
+		return err
+	}
+	var mod goModFile
+	if err := json.Unmarshal(output, &mod); err != nil {
+		return err
+	}
+
+	args := []string{"mod", "edit"}
+	for _, r := range mod.Replace {
+		if r.isLocal() && !filepath.IsAbs(r.New.Path) {
+			args = append(args, fmt.Sprintf("-replace=%v=%v", r.old(), localReplacement(r, srcDir)))
+		}
+	}
+	if len(args) == 2 {
+		return nil
+	}
+	_, err = RunGoCommand(p.Go.Command(dstDir, modCache, args...))
+	return err
+}
+
+// localReplacement returns the replacement of r, a relative directory is made absolute to root.
+func localReplacement(r goReplace, root string) string {
+	if !r.isLocal() {
+		return r.New.Path + " " + r.New.Version
+	}
+	if filepath.IsAbs(r.New.Path) {
+		return r.New.Path
+	}
+	return filepath.Join(root, filepath.FromSlash(r.New.Path))
+}
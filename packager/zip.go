@@ -0,0 +1,292 @@
+package packager
+
+import (
+	"archive/zip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/archive"
+)
+
+// FileSHA256 returns the hex encoded sha256 checksum of file.
+func FileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// zipOptions controls which files createZipArchive adds to the archive.
+type zipOptions struct {
+	// Include reports whether a file is added by its slash separated path
+	// relative to the archived directory, nil adds all files.
+	Include func(relPath string) bool
+	// IgnoreErrors logs files which can't be added instead of failing.
+	IgnoreErrors bool
+	// Compression is store, fast or best, empty uses the default level.
+	Compression string
+	// Reproducible writes the entries with a fixed time and normalized permissions.
+	Reproducible bool
+	// VolumeSize splits the archive into volumes of at most the size, 0 creates a single archive.
+	VolumeSize int64
+}
+
+var compressionLevels = map[string]archive.Compression{
+	"store": archive.CompressionStore,
+	"fast":  archive.CompressionFast,
+	"best":  archive.CompressionBest,
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// createZipArchive packs the content of dir into the zip archive dst, a dst of - writes
+// the archive to stdout. If the archive can't be created completely, dst is removed.
+func createZipArchive(dir, dst string, opts zipOptions) (err error) {
+	var inputSize int64
+	archiveOpts := archive.Options{
+		Compression:  compressionLevels[opts.Compression],
+		Include:      opts.Include,
+		Reproducible: opts.Reproducible,
+		OnAdd: func(name string, size int64) {
+			inputSize += size
+		},
+		OnError: func(name string, err error) error {
+			if !opts.IgnoreErrors {
+				return err
+			}
+			log.Printf("%v failed to add to archive: %v\n", errorRedPrefix, err)
+			return nil
+		},
+	}
+
+	var size int64
+	if opts.VolumeSize > 0 && dst != "-" {
+		size, err = createZipVolumes(dir, dst, opts.VolumeSize, archiveOpts)
+	} else {
+		size, err = createZipFile(dir, dst, archiveOpts)
+	}
+
+	if err == nil && inputSize > 0 {
+		// Module zips are already compressed, so compressing them again mostly costs time
+		verboseF("archive size %v of %v files (%.0f%%) with compression %v\n", ByteSize(size), ByteSize(inputSize),
+			float64(size)*100/float64(inputSize), color.BlueString(opts.Compression))
+	}
+	return err
+}
+
+// createZipFile writes the content of dir as zip archive to dst and returns its size.
+func createZipFile(dir, dst string, opts archive.Options) (size int64, err error) {
+	fw := os.Stdout
+	if dst != "-" {
+		if fw, err = os.OpenFile(dst, os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0666); err != nil {
+			return 0, err
+		}
+		defer func() {
+			if cerr := fw.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				_ = os.Remove(dst)
+			}
+		}()
+	}
+
+	cw := &countingWriter{w: fw}
+	err = archive.Create(dir, cw, opts)
+	return cw.n, err
+}
+
+// createZipVolumes writes the content of dir as zip archives <dst>.001, <dst>.002, ... of at most
+// volumeSize bytes and returns their total size. If the archive can't be created completely, all
+// volumes are removed.
+func createZipVolumes(dir, dst string, volumeSize int64, opts archive.Options) (size int64, err error) {
+	var volumes []string
+	defer func() {
+		if err != nil {
+			for _, v := range volumes {
+				_ = os.Remove(v)
+			}
+		}
+	}()
+
+	cw := &countingWriter{}
+	n, err := archive.CreateVolumes(dir, volumeSize, func(n int) (io.WriteCloser, error) {
+		name := volumeName(dst, n)
+		f, err := os.OpenFile(name, os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return nil, err
+		}
+		verboseF("creating volume: %v\n", color.BlueString(name))
+		volumes = append(volumes, name)
+		cw.w = f
+		return struct {
+			io.Writer
+			io.Closer
+		}{cw, f}, nil
+	}, opts)
+	if err == nil {
+		log.Printf("archive split into %v volumes of at most %v\n", n, ByteSize(volumeSize))
+	}
+	return cw.n, err
+}
+
+// volumeName returns the name of volume n of the archive dst.
+func volumeName(dst string, n int) string {
+	return fmt.Sprintf("%v.%03d", dst, n)
+}
+
+// ArchiveVolumes returns the volumes <src>.001, <src>.002, ... of an archive created with
+// --split-size, if src doesn't exist or is the first volume. Otherwise it returns src.
+func ArchiveVolumes(src string) []string {
+	base := strings.TrimSuffix(src, ".001")
+	if base == src && fileExists(src) {
+		return []string{src}
+	}
+
+	var volumes []string
+	for n := 1; fileExists(volumeName(base, n)); n++ {
+		volumes = append(volumes, volumeName(base, n))
+	}
+	if len(volumes) == 0 {
+		return []string{src}
+	}
+	return volumes
+}
+
+// trimLargeFiles removes the files larger than maxSize from the module sources in modCache. The
+// zips of the affected modules are rewritten without the files and their .ziphash files are
+// updated, the .info, .mod and list files and the module zips themselves are never skipped.
+func trimLargeFiles(modCache string, maxSize int64) error {
+	var skipped []string
+	dlDir := filepath.Join(modCache, "cache", "download")
+	err := filepath.Walk(dlDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() || filepath.Ext(path) != ".zip" || filepath.Base(filepath.Dir(path)) != "@v" {
+			return err
+		}
+
+		modPath, version := ModuleOfCachePath(RelSlashPath(modCache, path))
+		trimmed, err := trimModuleZip(path, maxSize)
+		if err != nil {
+			return fmt.Errorf("failed to trim %v@%v: %w", modPath, version, err)
+		}
+		for _, t := range trimmed {
+			skipped = append(skipped, fmt.Sprintf("%v@%v: %v", modPath, version, t))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The extracted sources mirror the module zips
+	err = filepath.Walk(modCache, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path == filepath.Join(modCache, "cache") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.Size() <= maxSize {
+			return nil
+		}
+
+		// go extracts the sources read-only
+		if err := os.Chmod(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return os.Remove(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(skipped) > 0 {
+		log.Printf("%v skipped %v files larger than %v, the modules no longer match their go.sum hashes:\n",
+			color.YellowString("warning:"), len(skipped), ByteSize(maxSize))
+		for _, s := range skipped {
+			log.Println("\t" + color.YellowString(s))
+		}
+	}
+	return nil
+}
+
+// trimModuleZip rewrites the module zip file without the entries larger than maxSize and updates
+// its .ziphash file. It returns the skipped entries relative to the module root with their size.
+func trimModuleZip(file string, maxSize int64) (skipped []string, err error) {
+	zr, err := zip.OpenReader(file)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var keep []*zip.File
+	for _, f := range zr.File {
+		if f.UncompressedSize64 > uint64(maxSize) {
+			name := f.Name[strings.Index(f.Name, "/")+1:]
+			skipped = append(skipped, fmt.Sprintf("%v (%v)", name, ByteSize(f.UncompressedSize64)))
+			continue
+		}
+		keep = append(keep, f)
+	}
+	if len(skipped) == 0 {
+		return nil, nil
+	}
+
+	tmpF, err := os.CreateTemp(filepath.Dir(file), "trim_*.zip")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmpF.Name())
+	defer tmpF.Close()
+
+	zw := zip.NewWriter(tmpF)
+	for _, f := range keep {
+		if err := zw.Copy(f); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	if err := tmpF.Close(); err != nil {
+		return nil, err
+	}
+	zr.Close()
+
+	if err := os.Rename(tmpF.Name(), file); err != nil {
+		return nil, err
+	}
+	hash, err := HashZip(file)
+	if err != nil {
+		return nil, err
+	}
+	hashF := strings.TrimSuffix(file, ".zip") + ".ziphash"
+	return skipped, os.WriteFile(hashF, []byte(hash), 0666)
+}
@@ -0,0 +1,95 @@
+package packager
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTrimLargeFiles(t *testing.T) {
+	modCache := t.TempDir()
+	large := strings.Repeat("x", 100)
+	dlDir := filepath.Join(modCache, "cache", "download", "example.com", "!a", "@v")
+	srcDir := filepath.Join(modCache, "example.com", "!a@v1.0.0")
+	for _, d := range []string{dlDir, filepath.Join(srcDir, "assets")} {
+		if err := os.MkdirAll(d, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files := map[string]string{"go.mod": "module example.com/A\n", "a.go": "package a\n", "assets/large.bin": large}
+	zipF := filepath.Join(dlDir, "v1.0.0.zip")
+	f, err := os.Create(zipF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create("example.com/!a@v1.0.0/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(srcDir, filepath.FromSlash(name)), []byte(content), 0444); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// Metadata files are never skipped, regardless of their size
+	for name, content := range map[string]string{"v1.0.0.info": large, "v1.0.0.mod": large, "v1.0.0.ziphash": "h1:old", "list": "v1.0.0\n"} {
+		if err := os.WriteFile(filepath.Join(dlDir, name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// go extracts the sources read-only
+	for _, d := range []string{filepath.Join(srcDir, "assets"), srcDir} {
+		if err := os.Chmod(d, 0555); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer os.Chmod(filepath.Join(srcDir, "assets"), 0755)
+	defer os.Chmod(srcDir, 0755)
+
+	captureLog(t)
+	if err := trimLargeFiles(modCache, 50); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.OpenReader(zipF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, strings.TrimPrefix(f.Name, "example.com/!a@v1.0.0/"))
+	}
+	zr.Close()
+	if got := strings.Join(names, ","); strings.Contains(got, "large.bin") || !strings.Contains(got, "go.mod") || !strings.Contains(got, "a.go") {
+		t.Errorf("zip entries = %v, want go.mod and a.go without assets/large.bin", got)
+	}
+
+	hash, err := HashZip(zipF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(dlDir, "v1.0.0.ziphash")); err != nil || string(data) != hash {
+		t.Errorf(".ziphash = %q (%v), want %q", data, err, hash)
+	}
+
+	for _, name := range []string{"v1.0.0.info", "v1.0.0.mod", "list"} {
+		if !fileExists(filepath.Join(dlDir, name)) {
+			t.Errorf("%v was removed", name)
+		}
+	}
+	if fileExists(filepath.Join(srcDir, "assets", "large.bin")) || !fileExists(filepath.Join(srcDir, "a.go")) {
+		t.Error("extracted sources aren't trimmed like the zip")
+	}
+}
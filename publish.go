@@ -1,30 +1,205 @@
 package main
 
 import (
+	"archive/zip"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
-	"unicode"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/archive"
+	"github.com/go-sharp/go-offline-packager/packager"
 )
 
 type publishCmd struct {
+	KeepGoing bool `long:"keep-going" description:"Continue publishing the remaining modules if a module fails, exits with an error nevertheless."`
+
 	PosArgs struct {
-		Archive string `positional-arg-name:"ARCHIVE" description:"Path to archive with dependencies. " default:"gop_dependencies.zip"`
+		Archive string `positional-arg-name:"ARCHIVE" description:"Path to archive with dependencies (- reads from stdin). " default:"gop_dependencies.zip"`
 	} `positional-args:"yes" required:"1"`
 }
 
+// extractArchive extracts the archive into workDir. If the archive is - it is
+// read from stdin, which is buffered to a temporary file as zip requires a seekable source.
+func (p publishCmd) extractArchive(workDir string) error {
+	if p.PosArgs.Archive != "-" {
+		return extractZipArchive(p.PosArgs.Archive, workDir)
+	}
+
+	// An empty TempDir uses the system temp directory
+	tmpF, err := os.CreateTemp(commonOpts.TempDir, "gop_stdin_*.zip")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpF.Name())
+	defer tmpF.Close()
+
+	verboseF("reading archive from stdin\n")
+	if _, err := io.Copy(tmpF, os.Stdin); err != nil {
+		return fmt.Errorf("failed to read archive from stdin: %w", err)
+	}
+	if err := tmpF.Close(); err != nil {
+		return err
+	}
+
+	return extractZipArchive(tmpF.Name(), workDir)
+}
+
+// errStopPublish stops walking the archive after a failure if --keep-going isn't set.
+var errStopPublish = errors.New("stop publishing")
+
+// publishFailures records the modules which failed to publish, it is safe for concurrent use.
+type publishFailures struct {
+	mu     sync.Mutex
+	failed []string
+}
+
+func (p *publishFailures) add(name string, err error) {
+	log.Println(errorRedPrefix, err)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.failed = append(p.failed, name)
+}
+
+func (p *publishFailures) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.failed)
+}
+
+// err returns an error listing all failed modules or nil if none failed.
+func (p *publishFailures) err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%v module(s) failed to publish: %v", len(p.failed), strings.Join(p.failed, ", "))
+}
+
+// stop reports whether publishing should stop because of a previous failure.
+func (p publishCmd) stop(failures *publishFailures) bool {
+	return !p.KeepGoing && failures.count() > 0
+}
+
+// Publisher publishes the modules of an extracted archive, it is driven by publishModules.
+type Publisher interface {
+	// Select reports whether the file or directory at relPath (relative to the walked directory and
+	// separated by forward slashes) is published. Selected directories aren't walked further, a
+	// directory is skipped by returning filepath.SkipDir.
+	Select(relPath string, info os.FileInfo) (bool, error)
+	// PublishModule publishes the selected file or directory dir, it must be safe for concurrent use.
+	PublishModule(dir, relPath string) error
+}
+
+// publishResult is the result of a path published by a Publisher.
+type publishResult struct {
+	relPath string
+	err     error
+}
+
+// publishModules walks root and publishes every file and directory selected by pub with jobs
+// concurrent workers. The failures are reported by a single goroutine, so the log output of the
+// workers isn't interleaved. Publishing stops at the first failure unless --keep-going is set.
+func (p publishCmd) publishModules(root string, jobs int, pub Publisher) error {
+	var failures publishFailures
+	workCh := make(chan string, 10)
+	resultCh := make(chan publishResult)
+	doneCh := make(chan struct{})
+
+	go func() {
+		for r := range resultCh {
+			// Module directories of the download cache are reported by their module path
+			failures.add(strings.TrimSuffix(r.relPath, "/@v"), r.err)
+		}
+		doneCh <- struct{}{}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range workCh {
+				// Drain the channel without publishing once publishing has stopped.
+				if p.stop(&failures) {
+					continue
+				}
+				relPath := packager.RelSlashPath(root, path)
+				if err := pub.PublishModule(path, relPath); err != nil {
+					resultCh <- publishResult{relPath: relPath, err: err}
+				}
+			}
+		}()
+	}
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p.stop(&failures) {
+			return errStopPublish
+		}
+		if path == root {
+			return nil
+		}
+
+		selected, err := pub.Select(packager.RelSlashPath(root, path), info)
+		if err != nil || !selected {
+			return err
+		}
+		workCh <- path
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	close(workCh)
+	wg.Wait()
+	close(resultCh)
+	<-doneCh
+
+	if err != nil && !errors.Is(err, errStopPublish) {
+		return err
+	}
+	return failures.err()
+}
+
 type JFrogPublishCmd struct {
 	publishCmd
 	JFrogBinPath string `long:"jfrog-bin" env:"GOP_JFROG_BIN" description:"Set full path to the jfrog-cli binary"`
 	Repo         string `short:"r" long:"repo" required:"yes" description:"Artifactory go repository name ex. go-local."`
+	Resume       string `long:"resume" description:"State file of the published modules, modules recorded in it are skipped and newly published ones are added."`
+	Jobs         int    `short:"j" long:"jobs" env:"GOP_JOBS" default:"4" description:"Number of modules published concurrently."`
+	Retries      int    `long:"retries" env:"GOP_RETRIES" default:"3" description:"Number of retries with exponential backoff of failed module uploads."`
+	NoRepoCheck  bool   `long:"no-repo-check" description:"Don't check that the repository exists before extracting the archive, ex. if the user can't list repositories."`
+	Direct       bool   `long:"direct" description:"Upload the modules with the REST API of Artifactory instead of jfrog-cli, requires --url and --token."`
+	URL          string `long:"url" description:"Base url of Artifactory for --direct ex. https://example.jfrog.io/artifactory."`
+	Token        string `long:"token" env:"GOP_JFROG_TOKEN" description:"Access token to authenticate with for --direct."`
+
+	// client is the http client of --direct.
+	client *http.Client
+	// workDir contains the extracted archive.
+	workDir string
+	// state contains the modules published according to --resume.
+	state *publishState
+	// skipped is the number of modules skipped because of the --resume state.
+	skipped int64
 }
 
 // Execute will be called for the last active (sub)command. The
@@ -33,99 +208,234 @@ type JFrogPublishCmd struct {
 // Parse method of the Parser.
 func (j *JFrogPublishCmd) Execute(args []string) error {
 	log.SetPrefix("Publish-JFrog: ")
-	if j.JFrogBinPath == "" {
-		if p, err := exec.LookPath("jfrog"); err == nil {
-			if !filepath.IsAbs(p) {
-				p, _ = filepath.Abs(p)
-			}
-			j.JFrogBinPath = p
+	if j.Direct {
+		if j.URL == "" || j.Token == "" {
+			return errors.New("--direct requires the url of Artifactory (--url) and an access token (--token)")
 		}
+		j.URL = strings.TrimSuffix(j.URL, "/")
+		j.client = &http.Client{Timeout: 5 * time.Minute}
+		log.Println("url:", color.BlueString(j.URL))
+	} else if err := j.setupCLI(); err != nil {
+		return err
 	}
 
-	if j.JFrogBinPath == "" {
-		log.Fatalln(errorRedPrefix, "missing jfrog cli: install jfrog-cli or specify valid binary path with --jfrog-bin")
+	if !j.NoRepoCheck {
+		verboseF("checking repository %v\n", color.BlueString(j.Repo))
+		if err := j.checkRepo(); err != nil {
+			return fmt.Errorf("invalid repository: %w", err)
+		}
 	}
 
-	cfg := j.getJFrogCfg()
-	if len(cfg) == 0 {
-		log.Fatalln(errorRedPrefix, "jfrog is not configured")
+	workDir, cleanFn := createTempWorkDir()
+	defer cleanFn()
+
+	log.Println("extracting archive")
+	if err := j.extractArchive(workDir); err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
 	}
 
-	// Print config used
-	for _, i := range cfg {
-		log.Println("config:", color.BlueString(i))
+	state, err := openPublishState(j.Resume)
+	if err != nil {
+		return fmt.Errorf("failed to read resume state: %w", err)
 	}
+	defer state.Close()
+	j.workDir, j.state = workDir, state
 
-	workDir, cleanFn := createTempWorkDir()
-	defer cleanFn()
+	log.Println("publishing modules")
+	err = j.publishModules(workDir, j.jobs(), j)
+	if skipped := atomic.LoadInt64(&j.skipped); skipped > 0 {
+		log.Printf("skipped %v modules already published according to %v\n", skipped, j.Resume)
+	}
+	if err != nil {
+		return err
+	}
 
-	log.Println("extracting archive")
-	if err := extractZipArchive(j.PosArgs.Archive, workDir); err != nil {
-		log.Fatalln(errorRedPrefix, " failed to extract archive:", err)
+	log.Println("modules successfully uploaded")
+	return nil
+}
+
+// jobs returns the number of concurrent uploads.
+func (j *JFrogPublishCmd) jobs() int {
+	if j.Jobs < 1 {
+		return packager.DefaultJobs
 	}
+	return j.Jobs
+}
 
-	workCh := make(chan string, 10)
-	doneCh := make(chan struct{})
-	go func() {
-		for mod := range workCh {
-			pkg := strings.Split(filepath.Base(mod), "@")
-			if len(pkg) != 2 {
-				log.Println(color.YellowString("warning:"), "invalid module directory:", filepath.Base(mod))
-				continue
-			}
+// Select selects the extracted module directories.
+func (j *JFrogPublishCmd) Select(relPath string, info os.FileInfo) (bool, error) {
+	// Only skip the download cache itself, module directories may start with cache as well
+	if relPath == "cache" {
+		return false, filepath.SkipDir
+	}
+	return info.IsDir() && strings.Contains(info.Name(), "@"), nil
+}
 
-			goModF := filepath.Join(mod, "go.mod")
-			if _, err := os.Stat(goModF); errors.Is(err, os.ErrNotExist) {
-				modName := filepath.Dir(strings.TrimPrefix(mod, workDir+string(filepath.Separator)))
-				modName = strToModuleName(modName + "/" + pkg[0])
-				if err := os.WriteFile(goModF, []byte(fmt.Sprintf("module %v\n", modName)), 0664); err != nil {
-					verboseF("%v: %v\n", errorRedPrefix, err)
-				}
-			}
+// PublishModule publishes the module directory dir, unless it is already published according to
+// the --resume state. A go.mod file is created for modules without one, as jfrog rt gp requires it.
+func (j *JFrogPublishCmd) PublishModule(dir, relPath string) error {
+	// relPath is the module cache directory <module>@<version>, so the module path contains the
+	// major version suffix like /v2, both are decoded from the case-insensitive encoding
+	pkg, ok := packager.SplitModuleVersion(packager.UnescapePath(relPath))
+	if !ok {
+		return fmt.Errorf("invalid module directory: %v", relPath)
+	}
 
-			cmd := exec.Command(j.JFrogBinPath, "rt", "gp", j.Repo, pkg[1])
-			cmd.Dir = mod
+	modName, version := pkg[0], pkg[1]
+	mod := modName + "@" + version
+	if j.state.contains(mod) {
+		verboseF("skipping published module %v\n", color.BlueString(mod))
+		atomic.AddInt64(&j.skipped, 1)
+		return nil
+	}
 
-			verboseF("publishing module %v %v\n", color.BlueString(pkg[0]), color.BlueString(pkg[1]))
-			if output, err := cmd.CombinedOutput(); err != nil {
-				log.Println(errorRedPrefix, "failed publish module:", pkg[0], pkg[1], err)
-				if len(output) > 0 {
-					verboseF("%v\n%v", errorRedPrefix, string(output))
-				}
-				continue
-			}
+	goModF := filepath.Join(dir, "go.mod")
+	if _, err := os.Stat(goModF); !j.Direct && errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(goModF, []byte(fmt.Sprintf("module %v\n", modName)), 0664); err != nil {
+			verboseF("%v: %v\n", errorRedPrefix, err)
 		}
-		doneCh <- struct{}{}
-	}()
+	}
 
-	log.Println("publishing modules")
-	err := filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
+	if output, err := j.publishModule(j.workDir, dir, modName, version); err != nil {
+		// The output is part of the error, so it is logged at once
+		if commonOpts.Verbose && len(output) > 0 {
+			return fmt.Errorf("failed publish module: %v %v %w\n%v", modName, version, err, strings.TrimSpace(string(output)))
 		}
+		return fmt.Errorf("failed publish module: %v %v %w", modName, version, err)
+	}
 
-		if strings.HasPrefix(info.Name(), "cache") {
-			return filepath.SkipDir
-		}
+	verboseF("published module %v\n", color.BlueString(mod))
+	if err := j.state.add(mod); err != nil {
+		log.Println(color.YellowString("warning:"), "failed to record published module:", err)
+	}
+	return nil
+}
 
-		if !info.IsDir() || !strings.Contains(info.Name(), "@") {
-			return nil
+// publishModule publishes the module version in dir with jfrog rt gp, or with --direct its files of the
+// download cache of workDir. It retries up to --retries times with exponential backoff, as the upload
+// can fail with a transient network error.
+func (j *JFrogPublishCmd) publishModule(workDir, dir, modName, version string) (output []byte, err error) {
+	for attempt := 0; ; attempt++ {
+		if j.Direct {
+			err = j.uploadModule(workDir, dir, modName, version)
+		} else {
+			cmd := exec.Command(j.JFrogBinPath, "rt", "gp", j.Repo, version)
+			cmd.Dir = dir
+			output, err = cmd.CombinedOutput()
+		}
+		if err == nil || attempt >= j.Retries {
+			return output, err
 		}
-		workCh <- path
-		return filepath.SkipDir
-	})
-	close(workCh)
 
-	<-doneCh
+		delay := time.Second << uint(attempt)
+		log.Printf("%v publishing %v failed, retry %v/%v in %v\n", color.YellowString("warning:"), modName+"@"+version, attempt+1, j.Retries, delay)
+		verboseF("%v: %v\n", color.RedString("error"), err)
+		time.Sleep(delay)
+	}
+}
 
-	log.Println("modules successfully uploaded")
+// publishState contains the module@version published according to the --resume state file, which
+// contains one module@version per line. It is safe for concurrent use.
+type publishState struct {
+	mu        sync.Mutex
+	file      *os.File
+	published map[string]struct{}
+}
+
+// openPublishState reads the state file and opens it to record published modules. Without state
+// file nothing is recorded.
+func openPublishState(name string) (*publishState, error) {
+	s := &publishState{published: map[string]struct{}{}}
+	if name == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, m := range strings.Fields(string(data)) {
+		s.published[m] = struct{}{}
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		// Complete a line cut off by an interrupted run
+		data = []byte{'\n'}
+	} else {
+		data = nil
+	}
+
+	if s.file, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0664); err != nil {
+		return nil, err
+	}
+	if _, err := s.file.Write(data); err != nil {
+		s.file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// contains reports whether mod is recorded as published.
+func (s *publishState) contains(mod string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.published[mod]
+	return ok
+}
+
+// add records mod as published. The line is written immediately, so an interrupted run
+// can be resumed.
+func (s *publishState) add(mod string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published[mod] = struct{}{}
+	if s.file == nil {
+		return nil
+	}
+	_, err := fmt.Fprintln(s.file, mod)
 	return err
 }
 
-func (j JFrogPublishCmd) getJFrogCfg() (config []string) {
+func (s *publishState) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+// setupCLI looks up the jfrog-cli binary and logs its server configuration.
+func (j *JFrogPublishCmd) setupCLI() error {
+	if j.JFrogBinPath == "" {
+		if p, err := exec.LookPath("jfrog"); err == nil {
+			if !filepath.IsAbs(p) {
+				p, _ = filepath.Abs(p)
+			}
+			j.JFrogBinPath = p
+		}
+	}
+
+	if j.JFrogBinPath == "" {
+		return errors.New("missing jfrog cli: install jfrog-cli or specify valid binary path with --jfrog-bin")
+	}
+
+	cfg, err := j.getJFrogCfg()
+	if err != nil {
+		return fmt.Errorf("failed to get jfrog config: %w", err)
+	}
+	if len(cfg) == 0 {
+		return errors.New("jfrog is not configured")
+	}
+
+	// Print config used
+	for _, i := range cfg {
+		log.Println("config:", color.BlueString(i))
+	}
+	return nil
+}
+
+func (j JFrogPublishCmd) getJFrogCfg() (config []string, err error) {
 	data, err := exec.Command(j.JFrogBinPath, "rt", "c", "show").Output()
 	if err != nil {
-		log.Fatalln(errorRedPrefix, "failed to get jfrog config:", err)
+		return nil, err
 	}
 
 	for _, v := range strings.Split(string(data), "\n") {
@@ -135,97 +445,259 @@ func (j JFrogPublishCmd) getJFrogCfg() (config []string) {
 		}
 	}
 
-	return config
+	return config, nil
+}
+
+// checkRepo checks with jfrog rt curl (or the REST API with --direct) that the repository exists and
+// is a go repository, so a wrong --repo fails before the archive is extracted. The repositories are
+// listed, which requires fewer permissions than reading the repository configuration.
+func (j JFrogPublishCmd) checkRepo() error {
+	var data []byte
+	var err error
+	if j.Direct {
+		data, err = j.get("/api/repositories")
+	} else {
+		data, err = exec.Command(j.JFrogBinPath, "rt", "curl", "-s", "-XGET", "/api/repositories").Output()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	var repos []struct {
+		Key         string `json:"key"`
+		PackageType string `json:"packageType"`
+	}
+	if err := json.Unmarshal(data, &repos); err != nil {
+		// Artifactory reports errors as {"errors": [{"status": 401, "message": "..."}]}
+		var resp struct {
+			Errors []struct {
+				Status  int    `json:"status"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		}
+		if json.Unmarshal(data, &resp) == nil && len(resp.Errors) > 0 {
+			return fmt.Errorf("failed to list repositories: %v %v", resp.Errors[0].Status, resp.Errors[0].Message)
+		}
+		return fmt.Errorf("failed to list repositories: %w", err)
+	}
+
+	for _, r := range repos {
+		if r.Key != j.Repo {
+			continue
+		}
+		if !strings.EqualFold(r.PackageType, "go") {
+			return fmt.Errorf("%v is a %v repository, not a go repository", j.Repo, r.PackageType)
+		}
+		return nil
+	}
+	return fmt.Errorf("repository %v not found", j.Repo)
 }
 
 // FolderPublishCmd publishes an archive of modules to a folder.
 type FolderPublishCmd struct {
 	publishCmd
-	Output string `short:"o" long:"out" required:"yes" description:"Output folder for the archive."`
+	Output   string   `short:"o" long:"out" required:"yes" description:"Output folder for the archive."`
+	FileMode fileMode `long:"file-mode" default:"0664" description:"Permissions of the published files (octal)."`
+	DirMode  fileMode `long:"dir-mode" default:"0774" description:"Permissions of the published directories (octal)."`
+
+	CacheCompat    bool `long:"cache-compat" description:"Publish as GOPATH module cache (pkg/mod) instead of a proxy folder."`
+	NoHints        bool `long:"no-hints" description:"Don't print hints on how to configure go to use the published folder."`
+	Overwrite      bool `long:"overwrite" description:"Overwrite existing files in the output folder if their content differs."`
+	ExtractSources bool `long:"extract-sources" description:"Additionally extract the module sources into <out>/modules/<module>@<version>."`
 }
 
 func (f FolderPublishCmd) Execute(args []string) error {
 	log.SetPrefix("Publish-Folder: ")
+	if f.ExtractSources && f.CacheCompat {
+		return errors.New("--extract-sources can't be used with --cache-compat, which extracts the sources to pkg/mod")
+	}
 
 	workDir, cleanFn := createTempWorkDir()
 	defer cleanFn()
 
 	log.Println("extracting archive")
-
-	defaultErrStr := errorRedPrefix + " failed to extract archive:"
-	if err := extractZipArchive(f.PosArgs.Archive, workDir); err != nil {
-		log.Fatalln(defaultErrStr, err)
+	if err := f.extractArchive(workDir); err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
 	}
+	return f.publish(workDir)
+}
 
-	// Prepare output folder
+// publish publishes the module cache in workDir to the output folder.
+func (f FolderPublishCmd) publish(workDir string) error {
 	fi, err := os.Stat(f.Output)
 	if err != nil {
 		if !errors.Is(err, os.ErrNotExist) {
-			log.Fatalln(defaultErrStr, err)
+			return fmt.Errorf("failed to access output folder: %w", err)
 		}
-		if err := os.MkdirAll(f.Output, 0774); err != nil {
-			log.Fatalln(defaultErrStr, err)
+		if err := mkdirAllMode(f.Output, os.FileMode(f.DirMode)); err != nil {
+			return fmt.Errorf("failed to create output folder: %w", err)
 		}
 	} else if !fi.IsDir() {
-		log.Fatalln(errorRedPrefix, "output is not a directory:", f.Output)
+		return fmt.Errorf("output is not a directory: %v", f.Output)
 	}
 
 	log.Println("processing files")
-	dirPrefix := filepath.Join(workDir, "cache", "download")
-	var wg sync.WaitGroup
-	err = filepath.Walk(dirPrefix, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
+	if err := f.publishModules(filepath.Join(workDir, "cache", "download"), packager.DefaultJobs, f); err != nil {
+		return err
+	}
 
-		relPath := strings.TrimLeft(strings.TrimPrefix(path, dirPrefix), string(filepath.Separator))
+	ppath, _ := filepath.Abs(f.Output)
+	if f.CacheCompat {
+		log.Println("published module cache to:", color.GreenString(ppath))
+		f.printHints("set GOPATH to use the module cache and disable the proxy:",
+			"go env -w GOPATH="+ppath, "go env -w GOPROXY=off", "go env -w GOFLAGS=-mod=mod")
+		return nil
+	}
+	log.Println("published archive to:", color.GreenString(ppath))
 
-		if strings.HasPrefix(relPath, "sumdb") && !info.IsDir() {
-			wg.Add(1)
-			go func() {
-				f.handleCopyFile(path, relPath)
-				wg.Done()
-			}()
-			return nil
-		}
+	// If the checksum database is served by the folder, go can verify the modules with GOSUMDB left on.
+	if folderExists(filepath.Join(ppath, "sumdb")) {
+		f.printHints("set GOPROXY to use folder for dependencies (checksum database is served by the folder):",
+			"go env -w GOPROXY="+fileURL(ppath))
+	} else {
+		f.printHints("set GOPROXY to use folder for dependencies and disable the checksum database:",
+			"go env -w GOPROXY="+fileURL(ppath), "go env -w GOSUMDB=off")
+	}
+	return nil
+}
 
-		if info.IsDir() && strings.HasSuffix(relPath, "@v") {
-			wg.Add(1)
-			go func() {
-				f.handleModule(path, dirPrefix)
-				wg.Done()
-			}()
-			return filepath.SkipDir
-		}
+// printHints prints the commands as a single block, so they can be copied at once.
+func (f FolderPublishCmd) printHints(title string, cmds ...string) {
+	if f.NoHints {
+		return
+	}
 
+	var b strings.Builder
+	fmt.Fprintf(&b, "hint: %v\n", title)
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "\t%v\n", color.BlueString(c))
+	}
+	fmt.Fprint(log.Writer(), b.String())
+}
+
+// cachePrefix is the path of the download cache in a GOPATH, used for --cache-compat.
+const cachePrefix = "pkg/mod/cache/download"
+
+// Select selects the module directories and the checksum database files of the download cache. With
+// --cache-compat all files except lock files are selected.
+func (f FolderPublishCmd) Select(relPath string, info os.FileInfo) (bool, error) {
+	if f.CacheCompat {
+		return !info.IsDir() && !strings.HasSuffix(relPath, ".lock") && info.Name() != "lock", nil
+	}
+	if strings.HasPrefix(relPath, "sumdb/") {
+		return !info.IsDir(), nil
+	}
+	return info.IsDir() && strings.HasSuffix(relPath, "@v"), nil
+}
+
+// PublishModule copies the module directory or file of the download cache to the output folder.
+// With --cache-compat every file is copied to the download cache of the GOPATH and the module
+// zips are extracted next to it, so it can be used by setting GOPATH to the output folder and
+// without any proxy.
+func (f FolderPublishCmd) PublishModule(dir, relPath string) error {
+	if f.CacheCompat {
+		if path.Base(relPath) == "list" {
+			return f.handleListFile(dir, cachePrefix+"/"+relPath)
+		}
+		if err := f.handleCopyFile(dir, cachePrefix+"/"+relPath); err != nil {
+			return err
+		}
+		if !strings.HasPrefix(relPath, "sumdb/") && strings.HasSuffix(relPath, ".zip") {
+			return f.handleExtractModule(dir, filepath.Join(f.Output, "pkg", "mod"))
+		}
 		return nil
-	})
+	}
 
-	wg.Wait()
+	if strings.HasPrefix(relPath, "sumdb/") {
+		return f.handleCopyFile(dir, relPath)
+	}
+	if err := f.handleModule(dir, relPath); err != nil || !f.ExtractSources {
+		return err
+	}
 
+	zips, err := filepath.Glob(filepath.Join(dir, "*.zip"))
 	if err != nil {
 		return err
 	}
+	for _, zipFile := range zips {
+		if err := f.handleExtractModule(zipFile, filepath.Join(f.Output, "modules")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	ppath, _ := filepath.Abs(f.Output)
-	log.Println("published archive to:", color.GreenString(ppath))
-	log.Printf("hint: set GOPROXY to use folder for dependencies:\n\t%v\n", color.BlueString("go env -w GOPROXY=file:///%v", ppath))
-	log.Printf("hint: in an air-gapped env set GOSUMDB to of:\n\t%v\n", color.BlueString("go env -w GOSUMDB=off"))
+// handleExtractModule extracts a module zip from the download cache into <modRoot>/<module>@<version>,
+// the module path is encoded like in the module cache.
+func (f FolderPublishCmd) handleExtractModule(zipFile, modRoot string) error {
+	zr, err := zip.OpenReader(zipFile)
+	if err != nil {
+		return fmt.Errorf("failed to open module zip: %w", err)
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		// Entries of a module zip are prefixed with <module>@<version>/
+		at := strings.Index(zf.Name, "@")
+		if at < 0 {
+			log.Println(color.YellowString("warning:"), "invalid module zip entry:", zf.Name)
+			continue
+		}
+		slash := strings.Index(zf.Name[at:], "/")
+		if slash < 0 || strings.HasSuffix(zf.Name, "/") {
+			continue
+		}
+
+		modDir := filepath.Join(modRoot, filepath.FromSlash(packager.EscapePath(zf.Name[:at+slash])))
+		dstPath, err := archive.TargetPath(modDir, zf.Name[at+slash+1:])
+		if err != nil {
+			return err
+		}
+
+		if _, err := os.Stat(dstPath); err == nil {
+			reason := "file exists"
+			if f.Overwrite {
+				if sum, err := fileCRC32(dstPath); err != nil {
+					reason = err.Error()
+				} else if sum == zf.CRC32 {
+					reason = "file is up to date"
+				} else if err := os.Remove(dstPath); err != nil {
+					reason = err.Error()
+				} else {
+					verboseF("overwriting file %v\n", color.YellowString(zf.Name))
+					reason = ""
+				}
+			}
+
+			if reason != "" {
+				verboseF("skipping file %v: %v\n", color.YellowString(zf.Name), reason)
+				continue
+			}
+		}
+
+		// We don't care if we can't create dir, it will fail when we try to extract the file
+		_ = mkdirAllMode(filepath.Dir(dstPath), os.FileMode(f.DirMode))
+		if err := archive.ExtractFile(zf, dstPath); err != nil {
+			return err
+		}
+		_ = os.Chmod(dstPath, os.FileMode(f.FileMode))
+	}
 	return nil
 }
 
-func (f FolderPublishCmd) handleModule(path, prefix string) {
+// handleModule copies the files of the module directory path of the download cache to relPath in
+// the output folder and updates the list file with the versions of all .mod files in the output
+// folder and the versions already listed, so publishing several archives into one folder grows it.
+func (f FolderPublishCmd) handleModule(path, relPath string) error {
 	modD, err := os.Open(path)
 	if err != nil {
-		log.Println(errorRedPrefix, "failed to read module directory: ", err)
-		return
+		return fmt.Errorf("failed to read module directory: %w", err)
 	}
 	defer modD.Close()
 
 	files, err := modD.Readdirnames(0)
 	if err != nil {
-		log.Println(errorRedPrefix, "failed to read module directory: ", err)
-		return
+		return fmt.Errorf("failed to read module directory: %w", err)
 	}
 
 	// Copy files
@@ -234,98 +706,184 @@ func (f FolderPublishCmd) handleModule(path, prefix string) {
 			continue
 		}
 
-		srcF := filepath.Join(path, fi)
-		f.handleCopyFile(srcF, strings.TrimLeft(strings.TrimPrefix(srcF, prefix), string(filepath.Separator)))
+		if err := f.handleCopyFile(filepath.Join(path, fi), relPath+"/"+fi); err != nil {
+			return err
+		}
 	}
 
 	var version []string
-	dstPath := filepath.Join(f.Output, strings.TrimLeft(strings.TrimPrefix(path, prefix), string(filepath.Separator)))
+	dstPath := filepath.Join(f.Output, filepath.FromSlash(relPath))
 	dstF, err := os.Open(dstPath)
 	if err != nil {
-		log.Println(errorRedPrefix, "failed to update list file: ", err)
-		return
+		return fmt.Errorf("failed to update list file: %w", err)
 	}
 	defer dstF.Close()
 
 	modules, err := dstF.Readdirnames(0)
 	if err != nil {
-		log.Println(errorRedPrefix, "failed to update list file: ", err)
-		return
+		return fmt.Errorf("failed to update list file: %w", err)
 	}
 
+	// The file names are case-encoded, the list file contains the versions
 	for _, v := range modules {
 		if strings.HasSuffix(v, ".mod") {
-			version = append(version, strings.TrimSuffix(v, ".mod"))
+			version = append(version, packager.UnescapePath(strings.TrimSuffix(v, ".mod")))
 		}
 	}
 
-	content := []byte(strings.Join(version, "\n"))
-	content = append(content, '\n')
-	if err := os.WriteFile(filepath.Join(dstPath, "list"), content, 0664); err != nil {
-		log.Println(errorRedPrefix, "failed to update list file: ", err)
-		return
+	// Versions listed by an earlier publish are kept
+	return f.writeListFile(filepath.Join(dstPath, "list"), version)
+}
+
+// handleListFile merges the versions of the list file path into the list file relPath of the output
+// folder, so the versions published before aren't dropped.
+func (f FolderPublishCmd) handleListFile(path, relPath string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read list file: %w", err)
 	}
+	return f.writeListFile(filepath.Join(f.Output, filepath.FromSlash(relPath)), strings.Fields(string(data)))
 }
 
-func (f FolderPublishCmd) handleCopyFile(path, relPath string) {
-	dstPath := filepath.Join(f.Output, relPath)
+// writeListFile writes the list file with the versions and the versions already listed in it.
+// Case-encoded versions written by earlier releases are decoded.
+func (f FolderPublishCmd) writeListFile(listF string, versions []string) error {
+	merged := map[string]struct{}{}
+	if data, err := os.ReadFile(listF); err == nil {
+		for _, v := range strings.Fields(string(data)) {
+			merged[packager.UnescapePath(v)] = struct{}{}
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read list file: %w", err)
+	}
+	for _, v := range versions {
+		merged[v] = struct{}{}
+	}
+
+	list := make([]string, 0, len(merged))
+	for v := range merged {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+
+	// We don't care if we can't create dir, it will fail when we try to write the file
+	_ = mkdirAllMode(filepath.Dir(listF), os.FileMode(f.DirMode))
+	if err := os.WriteFile(listF, []byte(strings.Join(list, "\n")+"\n"), os.FileMode(f.FileMode)); err != nil {
+		return fmt.Errorf("failed to update list file: %w", err)
+	}
+	if err := os.Chmod(listF, os.FileMode(f.FileMode)); err != nil {
+		return fmt.Errorf("failed to set permissions of list file: %w", err)
+	}
+	return nil
+}
+
+// handleCopyFile copies the file path to relPath in the output folder. relPath is separated by
+// forward slashes, like the entries of the archive, independent of the operating system.
+func (f FolderPublishCmd) handleCopyFile(path, relPath string) error {
+	dstPath := filepath.Join(f.Output, filepath.FromSlash(relPath))
 	if _, err := os.Stat(dstPath); !errors.Is(err, os.ErrNotExist) {
 		reason := "file exists"
 		if err != nil {
 			reason = err.Error()
+		} else if f.Overwrite {
+			if equal, err := sameContent(path, dstPath); err != nil {
+				reason = err.Error()
+			} else if equal {
+				reason = "file is up to date"
+			} else if err := os.Remove(dstPath); err != nil {
+				reason = err.Error()
+			} else {
+				verboseF("overwriting file %v\n", color.YellowString(relPath))
+				reason = ""
+			}
+		}
+
+		if reason != "" {
+			verboseF("skipping file %v: %v\n", color.YellowString(relPath), reason)
+			return nil
 		}
-		verboseF("skipping file %v: %v\n", color.YellowString(relPath), reason)
-		return
 	}
 
 	dstDir := filepath.Dir(dstPath)
 	if st, err := os.Stat(dstDir); errors.Is(err, os.ErrNotExist) {
 		// We don't care if we can't create dir, it will fail when we try to copy the file
-		_ = os.MkdirAll(dstDir, 0774)
+		_ = mkdirAllMode(dstDir, os.FileMode(f.DirMode))
 	} else if !st.IsDir() {
-		log.Println(errorRedPrefix, "failed to copy file destination is not a directory: ", dstDir)
-		return
+		return fmt.Errorf("failed to copy file destination is not a directory: %v", dstDir)
 	}
 
 	srcF, err := os.Open(path)
 	if err != nil {
-		log.Println(errorRedPrefix, "failed to read src:", err)
-		return
+		return fmt.Errorf("failed to read src: %w", err)
 	}
 	defer srcF.Close()
 
-	dstF, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0664)
+	dstF, err := os.OpenFile(dstPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, os.FileMode(f.FileMode))
 	if err != nil {
-		log.Println(errorRedPrefix, "failed to create file:", err)
-		return
+		return fmt.Errorf("failed to create file: %w", err)
 	}
 	defer dstF.Close()
 
 	if _, err := io.Copy(dstF, srcF); err != nil {
+		return fmt.Errorf("failed to copy file: %w", err)
+	}
 
-		log.Println(errorRedPrefix, "failed to copy file:", err)
-		return
+	// The mode passed to OpenFile is subject to the umask, so set it explicitly.
+	if err := dstF.Chmod(os.FileMode(f.FileMode)); err != nil {
+		return fmt.Errorf("failed to set file permissions: %w", err)
 	}
+	return nil
 }
 
-func strToModuleName(name string) string {
-	name = filepath.ToSlash(name)
-	var modName []rune
+// sameContent reports whether both files have the same sha256 checksum.
+func sameContent(file1, file2 string) (bool, error) {
+	sum1, err := packager.FileSHA256(file1)
+	if err != nil {
+		return false, err
+	}
+	sum2, err := packager.FileSHA256(file2)
+	if err != nil {
+		return false, err
+	}
+	return sum1 == sum2, nil
+}
 
-	nextToUpper := false
-	for _, v := range name {
-		if nextToUpper {
-			modName = append(modName, unicode.ToUpper(v))
-			nextToUpper = false
-			continue
+// fileCRC32 returns the CRC-32 checksum of the file, as stored in zip archives.
+func fileCRC32(file string) (uint32, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum32(), nil
+}
+
+// mkdirAllMode works like os.MkdirAll, but sets the permissions of all
+// created directories to mode regardless of the umask.
+func mkdirAllMode(dir string, mode os.FileMode) error {
+	if st, err := os.Stat(dir); err == nil {
+		if !st.IsDir() {
+			return fmt.Errorf("not a directory: %v", dir)
 		}
+		return nil
+	}
 
-		if v == '!' {
-			nextToUpper = true
-			continue
+	if parent := filepath.Dir(dir); parent != dir {
+		if err := mkdirAllMode(parent, mode); err != nil {
+			return err
 		}
-		modName = append(modName, v)
 	}
 
-	return string(modName)
+	if err := os.Mkdir(dir, mode); err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(dir, mode)
 }
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeTestArchive writes an archive with the entry names and contents written as is to file.
+func writeTestArchive(t *testing.T, file string, entries ...string) {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		w, err := zw.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entries[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, buf.Bytes(), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// moduleZip returns a module zip of mod@version with a go.mod file.
+func moduleZip(t *testing.T, mod, version string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create(mod + "@" + version + "/go.mod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("module " + mod + "\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestFolderPublishBackslashArchive(t *testing.T) {
+	dir := t.TempDir()
+	src, out := filepath.Join(dir, "gop_dependencies.zip"), filepath.Join(dir, "out")
+
+	// Archive created on Windows with backslash separated entry names
+	writeTestArchive(t, src,
+		`cache\download\example.com\!foo\@v\list`, "v1.0.0\n",
+		`cache\download\example.com\!foo\@v\v1.0.0.info`, `{"Version":"v1.0.0"}`,
+		`cache\download\example.com\!foo\@v\v1.0.0.mod`, "module example.com/Foo\n",
+		`cache\download\example.com\!foo\@v\v1.0.0.zip`, moduleZip(t, "example.com/Foo", "v1.0.0"),
+	)
+
+	f := FolderPublishCmd{Output: out, FileMode: 0664, DirMode: 0774, NoHints: true}
+	f.PosArgs.Archive = src
+	if err := f.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	modDir := filepath.Join(out, "example.com", "!foo", "@v")
+	for _, name := range []string{"v1.0.0.info", "v1.0.0.mod", "v1.0.0.zip"} {
+		if !fileExists(filepath.Join(modDir, name)) {
+			t.Errorf("%v not published", name)
+		}
+	}
+	list, err := os.ReadFile(filepath.Join(modDir, "list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(list)) != "v1.0.0" {
+		t.Errorf("list = %q, want v1.0.0", list)
+	}
+
+	// Nothing is published with the backslashes as part of a file name
+	entries, err := os.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.Contains(e.Name(), `\`) {
+			t.Errorf("published file name with backslash: %q", e.Name())
+		}
+	}
+}
+
+// stubPublisher publishes the .zip files of a directory and fails for the files in fail.
+type stubPublisher struct {
+	mu        sync.Mutex
+	published []string
+	fail      map[string]bool
+}
+
+func (s *stubPublisher) Select(relPath string, info os.FileInfo) (bool, error) {
+	return strings.HasSuffix(relPath, ".zip"), nil
+}
+
+func (s *stubPublisher) PublishModule(dir, relPath string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.published = append(s.published, relPath)
+	if s.fail[relPath] {
+		return fmt.Errorf("failed to publish %v", relPath)
+	}
+	return nil
+}
+
+func TestPublishModulesKeepGoing(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.zip", "b.zip", "c.zip"} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pub := &stubPublisher{fail: map[string]bool{"a.zip": true}}
+	if err := (publishCmd{}).publishModules(root, 1, pub); err == nil || !strings.Contains(err.Error(), "a.zip") {
+		t.Fatalf("publishModules() error = %v, want the failure of a.zip", err)
+	}
+
+	pub = &stubPublisher{fail: map[string]bool{"b.zip": true}}
+	err := publishCmd{KeepGoing: true}.publishModules(root, 1, pub)
+	if err == nil || !strings.Contains(err.Error(), "b.zip") {
+		t.Fatalf("publishModules() with --keep-going error = %v, want the failure of b.zip", err)
+	}
+	sort.Strings(pub.published)
+	if got := strings.Join(pub.published, ","); got != "a.zip,b.zip,c.zip" {
+		t.Errorf("published %v with --keep-going, want all modules", got)
+	}
+}
+
+func TestFolderPublishListUpperCaseVersion(t *testing.T) {
+	dir := t.TempDir()
+	src, out := filepath.Join(dir, "gop_dependencies.zip"), filepath.Join(dir, "out")
+
+	writeTestArchive(t, src,
+		"cache/download/example.com/a/@v/list", "v1.0.0-RC1\n",
+		"cache/download/example.com/a/@v/v1.0.0-!r!c1.info", `{"Version":"v1.0.0-RC1"}`,
+		"cache/download/example.com/a/@v/v1.0.0-!r!c1.mod", "module example.com/a\n",
+		"cache/download/example.com/a/@v/v1.0.0-!r!c1.zip", moduleZip(t, "example.com/a", "v1.0.0-RC1"),
+	)
+	// A list file with the encoded version of an earlier publish is repaired
+	modDir := filepath.Join(out, "example.com", "a", "@v")
+	if err := os.MkdirAll(modDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(modDir, "list"), []byte("v0.9.0-!b!e!t!a\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	f := FolderPublishCmd{Output: out, FileMode: 0664, DirMode: 0774, NoHints: true}
+	f.PosArgs.Archive = src
+	if err := f.Execute(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := os.ReadFile(filepath.Join(modDir, "list"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Fields(string(list)), []string{"v0.9.0-BETA", "v1.0.0-RC1"}; strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("list = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/archive"
+)
+
+// zipEpoch is the fixed modification time of entries in normalized archives,
+// the earliest time representable in a zip file.
+var zipEpoch = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// RepackCmd re-creates an existing archive as normalized archive without downloading.
+type RepackCmd struct {
+	Output  string `short:"o" long:"out" required:"yes" description:"Output file name of the normalized zip archive."`
+	PosArgs struct {
+		Archive string `positional-arg-name:"ARCHIVE" description:"Path to archive with dependencies."`
+	} `positional-args:"yes" required:"1"`
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (r *RepackCmd) Execute(args []string) error {
+	log.SetPrefix("Repack: ")
+
+	zr, err := zip.OpenReader(r.PosArgs.Archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+
+	log.Println("creating archive")
+	if err := repackZipArchive(&zr.Reader, r.Output); err != nil {
+		_ = os.Remove(r.Output)
+		return fmt.Errorf("failed to repack archive: %w", err)
+	}
+
+	log.Println("archive created:", color.GreenString(r.Output))
+	return nil
+}
+
+// repackZipArchive writes all files of zr sorted by name and with
+// a fixed modification time to the new archive dst. Entry names with
+// backslashes are repaired, entries escaping the archive root are rejected.
+func repackZipArchive(zr *zip.Reader, dst string) (err error) {
+	fw, err := os.OpenFile(dst, os.O_EXCL|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := fw.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	names := make(map[*zip.File]string, len(zr.File))
+	repaired := 0
+	for _, f := range zr.File {
+		name, err := repackName(f.Name)
+		if err != nil {
+			return err
+		}
+		if strings.Contains(f.Name, "\\") {
+			repaired++
+		}
+		names[f] = name
+	}
+
+	files := append([]*zip.File(nil), zr.File...)
+	sort.Slice(files, func(i, j int) bool { return names[files[i]] < names[files[j]] })
+
+	zw := zip.NewWriter(fw)
+	for _, f := range files {
+		if err := repackFile(f, names[f], zw); err != nil {
+			return fmt.Errorf("%v: %w", f.Name, err)
+		}
+	}
+	if repaired > 0 {
+		log.Printf("%v repaired %v entry names with backslashes\n", color.YellowString("warning:"), repaired)
+	}
+	return zw.Close()
+}
+
+// repackName returns the normalized entry name of name, directories keep their trailing slash.
+func repackName(name string) (string, error) {
+	normalized := archive.EntryName(name)
+	if normalized == "." || normalized == ".." || strings.HasPrefix(normalized, "../") {
+		return "", fmt.Errorf("%w: %v", archive.ErrIllegalPath, name)
+	}
+	if strings.HasSuffix(strings.ReplaceAll(name, "\\", "/"), "/") {
+		normalized += "/"
+	}
+	return normalized, nil
+}
+
+func repackFile(f *zip.File, name string, zw *zip.Writer) error {
+	fh := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: zipEpoch}
+	fh.SetMode(f.Mode())
+
+	writer, err := zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+
+	reader, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	_, err = io.Copy(writer, reader)
+	return err
+}
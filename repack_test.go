@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-sharp/go-offline-packager/archive"
+)
+
+// testZip returns a reader of an archive with the given entry names and contents written as is.
+func testZip(t *testing.T, entries ...string) *zip.Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		w, err := zw.Create(entries[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(w, entries[i+1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return zr
+}
+
+func TestRepackZipArchiveBackslashes(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "repacked.zip")
+	zr := testZip(t,
+		`cache\download\b\@v\v1.0.0.mod`, "module b\n",
+		`cache\download\a\@v\list`, "v1.0.0\n",
+		"cache/download/a/@v/v1.0.0.mod", "module a\n",
+	)
+	if err := repackZipArchive(zr, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := zip.OpenReader(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+
+	var names []string
+	for _, f := range rc.File {
+		names = append(names, f.Name)
+		if !f.Modified.Equal(zipEpoch) {
+			t.Errorf("%v has time %v, want %v", f.Name, f.Modified, zipEpoch)
+		}
+	}
+	want := "cache/download/a/@v/list,cache/download/a/@v/v1.0.0.mod,cache/download/b/@v/v1.0.0.mod"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("entries = %v, want %v", got, want)
+	}
+}
+
+func TestRepackZipArchiveTraversal(t *testing.T) {
+	for _, name := range []string{`..\evil`, `cache\..\..\evil`, "../evil"} {
+		t.Run(name, func(t *testing.T) {
+			dst := filepath.Join(t.TempDir(), "repacked.zip")
+			zr := testZip(t, `cache\download\a\@v\list`, "v1.0.0\n", name, "evil")
+			if err := repackZipArchive(zr, dst); !errors.Is(err, archive.ErrIllegalPath) {
+				t.Fatalf("error = %v, want ErrIllegalPath", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3PublishCmd publishes an archive of modules to an S3 bucket in the proxy layout. The credentials
+// and the region are resolved by the default configuration of the AWS SDK (AWS_* environment
+// variables, shared config and credential files, SSO and instance roles).
+type S3PublishCmd struct {
+	publishCmd
+	Bucket    string `long:"bucket" required:"yes" description:"Name of the S3 bucket."`
+	Prefix    string `long:"prefix" description:"Key prefix of the proxy folder in the bucket."`
+	Region    string `long:"region" description:"AWS region of the bucket, defaults to the region of the AWS configuration (AWS_REGION, ~/.aws/config)."`
+	Overwrite bool   `long:"overwrite" description:"Upload all files, including the ones already present in the bucket."`
+
+	client *s3.Client
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (s *S3PublishCmd) Execute(args []string) error {
+	log.SetPrefix("Publish-S3: ")
+
+	var opts []func(*config.LoadOptions) error
+	if s.Region != "" {
+		opts = append(opts, config.WithRegion(s.Region))
+	}
+	cfg, err := config.LoadDefaultConfig(context.Background(), opts...)
+	if err != nil {
+		return fmt.Errorf("failed to load aws configuration: %w", err)
+	}
+	s.client = s3.NewFromConfig(cfg)
+
+	return publishObjects(s.publishCmd, s, s.Prefix, s.Overwrite)
+}
+
+func (s *S3PublishCmd) url(prefix string) string {
+	if prefix == "" {
+		return "s3://" + s.Bucket
+	}
+	return "s3://" + s.Bucket + "/" + prefix
+}
+
+func (s *S3PublishCmd) putObject(key, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = s.client.PutObject(context.Background(), &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   f,
+	})
+	return err
+}
+
+// listKeys returns the keys of all objects below prefix, relative to the prefix.
+func (s *S3PublishCmd) listKeys(prefix string) (map[string]struct{}, error) {
+	input := &s3.ListObjectsV2Input{Bucket: aws.String(s.Bucket)}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix + "/")
+	}
+
+	keys := map[string]struct{}{}
+	pages := s3.NewListObjectsV2Paginator(s.client, input)
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			keys[strings.TrimPrefix(strings.TrimPrefix(aws.ToString(obj.Key), prefix), "/")] = struct{}{}
+		}
+	}
+	return keys, nil
+}
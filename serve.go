@@ -0,0 +1,152 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// ServeCmd serves the modules of an archive with the GOPROXY protocol.
+type ServeCmd struct {
+	Archive string `short:"a" long:"archive" required:"yes" description:"Path to archive with dependencies."`
+	Addr    string `long:"addr" default:":8080" description:"Address to listen on."`
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (s *ServeCmd) Execute(args []string) error {
+	log.SetPrefix("Serve: ")
+
+	zr, err := zip.OpenReader(s.Archive)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer zr.Close()
+
+	proxy := newArchiveProxy(&zr.Reader)
+	log.Printf("serving %v module versions of %v\n", proxy.versions, color.BlueString(s.Archive))
+
+	srv := &http.Server{Addr: s.Addr, Handler: proxy}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, os.Interrupt)
+		<-sigCh
+
+		log.Println("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Println(errorRedPrefix, "failed to shut down:", err)
+		}
+	}()
+
+	log.Println("listening on:", color.GreenString(s.Addr))
+	if _, port, err := net.SplitHostPort(s.Addr); err == nil {
+		log.Println("hint: set GOPROXY to use the server for dependencies:",
+			color.BlueString("go env -w GOPROXY=http://<host>:%v", port))
+	}
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-done
+	return nil
+}
+
+// archiveProxy serves the download cache of an archive directly from the zip file.
+type archiveProxy struct {
+	// files maps the request path (/<module>/@v/<file>) to the archive entry.
+	files map[string]*zip.File
+	// lists maps the request path of a list file to the versions of the module.
+	lists    map[string][]string
+	versions int
+}
+
+func newArchiveProxy(zr *zip.Reader) *archiveProxy {
+	p := &archiveProxy{files: map[string]*zip.File{}, lists: map[string][]string{}}
+	for _, f := range zr.File {
+		name := strings.ReplaceAll(f.Name, "\\", "/")
+		if !strings.HasPrefix(name, "cache/download/") || strings.HasSuffix(name, "/") {
+			continue
+		}
+
+		urlPath := strings.TrimPrefix(name, "cache/download")
+		p.files[urlPath] = f
+
+		// The list file of the archive may be incomplete, so it is built from the .mod files
+		if dir, file := path.Split(urlPath); path.Base(dir) == "@v" && strings.HasSuffix(file, ".mod") {
+			p.lists[dir+"list"] = append(p.lists[dir+"list"], packager.UnescapePath(strings.TrimSuffix(file, ".mod")))
+			p.versions++
+		}
+	}
+
+	for _, versions := range p.lists {
+		sort.Strings(versions)
+	}
+	return p
+}
+
+func (p *archiveProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	urlPath := path.Clean(r.URL.Path)
+	if versions, ok := p.lists[urlPath]; ok {
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+		_, _ = io.WriteString(w, strings.Join(versions, "\n")+"\n")
+		verboseF("served list: %v\n", color.BlueString(urlPath))
+		return
+	}
+
+	f, ok := p.files[urlPath]
+	if !ok || strings.HasSuffix(urlPath, ".lock") {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch path.Ext(urlPath) {
+	case ".info":
+		w.Header().Set("Content-Type", "application/json")
+	case ".zip":
+		w.Header().Set("Content-Type", "application/zip")
+	default:
+		w.Header().Set("Content-Type", "text/plain; charset=UTF-8")
+	}
+	w.Header().Set("Content-Length", strconv.FormatUint(f.UncompressedSize64, 10))
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Println(errorRedPrefix, "failed to serve", urlPath, ":", err)
+		return
+	}
+	log.Println("served:", color.BlueString(packager.UnescapePath(strings.TrimPrefix(urlPath, "/"))))
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// objectStore is a bucket-like publish target, which serves the download cache of the archive
+// in the proxy layout like publish-folder. The existing keys are listed once, so the objects already
+// present are skipped without a request per file, and the remaining files are uploaded module by
+// module by publishModules.
+type objectStore interface {
+	// url returns the url of prefix in the store.
+	url(prefix string) string
+	// listKeys returns the keys of all objects below prefix, relative to the prefix.
+	listKeys(prefix string) (map[string]struct{}, error)
+	// putObject uploads file as object key, it must be safe for concurrent use.
+	putObject(key, file string) error
+}
+
+// publishObjects extracts the archive and uploads its download cache to the store below prefix. The
+// objects already present in the store are skipped unless overwrite is set.
+func publishObjects(p publishCmd, store objectStore, prefix string, overwrite bool) error {
+	workDir, cleanFn := createTempWorkDir()
+	defer cleanFn()
+
+	log.Println("extracting archive")
+	if err := p.extractArchive(workDir); err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	prefix = strings.Trim(prefix, "/")
+	log.Println("destination:", color.BlueString(store.url(prefix)))
+
+	existing, err := store.listKeys(prefix)
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+
+	dirPrefix := filepath.Join(workDir, "cache", "download")
+	skipped, err := prepareUpload(dirPrefix, existing, overwrite)
+	if err != nil {
+		return err
+	}
+	verboseF("skipping %v files already present\n", skipped)
+
+	log.Println("uploading files")
+	if err := p.publishModules(dirPrefix, packager.DefaultJobs, storePublisher{store: store, prefix: prefix}); err != nil {
+		return err
+	}
+
+	log.Println("modules successfully uploaded to:", color.GreenString(store.url(prefix)))
+	return nil
+}
+
+// prepareUpload removes the files already present in the store and lock files from the extracted
+// download cache, so only the remaining files are uploaded. The list files are rewritten with
+// the versions of the store and the archive. With overwrite all files are uploaded. It returns the
+// number of skipped files.
+func prepareUpload(dirPrefix string, existing map[string]struct{}, overwrite bool) (skipped int, err error) {
+	err = filepath.Walk(dirPrefix, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+
+		relPath := packager.RelSlashPath(dirPrefix, file)
+		if strings.HasSuffix(relPath, ".lock") || info.Name() == "lock" {
+			return os.Remove(file)
+		}
+
+		if info.Name() == "list" && path.Base(path.Dir(relPath)) == "@v" {
+			return writeMergedList(file, path.Dir(relPath), existing)
+		}
+
+		if _, ok := existing[relPath]; ok && !overwrite {
+			skipped++
+			return os.Remove(file)
+		}
+		return nil
+	})
+	return skipped, err
+}
+
+// writeMergedList writes the list file with the versions of the .mod files next to it and
+// the .mod files present in the store below modDir.
+func writeMergedList(listFile, modDir string, existing map[string]struct{}) error {
+	versions := map[string]struct{}{}
+	files, err := os.ReadDir(filepath.Dir(listFile))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".mod") {
+			versions[packager.UnescapePath(strings.TrimSuffix(f.Name(), ".mod"))] = struct{}{}
+		}
+	}
+	for key := range existing {
+		if path.Dir(key) == modDir && strings.HasSuffix(key, ".mod") {
+			versions[packager.UnescapePath(strings.TrimSuffix(path.Base(key), ".mod"))] = struct{}{}
+		}
+	}
+
+	list := make([]string, 0, len(versions))
+	for v := range versions {
+		list = append(list, v)
+	}
+	sort.Strings(list)
+	return os.WriteFile(listFile, []byte(strings.Join(list, "\n")+"\n"), 0664)
+}
+
+// storePublisher publishes the module directories and the checksum database files of the download
+// cache to an objectStore below prefix.
+type storePublisher struct {
+	store  objectStore
+	prefix string
+}
+
+// Select selects the module directories and the checksum database files like publish-folder.
+func (s storePublisher) Select(relPath string, info os.FileInfo) (bool, error) {
+	if strings.HasPrefix(relPath, "sumdb/") {
+		return !info.IsDir(), nil
+	}
+	return info.IsDir() && strings.HasSuffix(relPath, "@v"), nil
+}
+
+// PublishModule uploads the files of a module directory or a single checksum database file. The list
+// file is uploaded last, so a version is only listed once its files are present in the store.
+func (s storePublisher) PublishModule(dir, relPath string) error {
+	if strings.HasPrefix(relPath, "sumdb/") {
+		return s.put(dir, relPath)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	hasList := false
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		if f.Name() == "list" {
+			hasList = true
+			continue
+		}
+		if err := s.put(filepath.Join(dir, f.Name()), relPath+"/"+f.Name()); err != nil {
+			return err
+		}
+	}
+	if hasList {
+		return s.put(filepath.Join(dir, "list"), relPath+"/list")
+	}
+	return nil
+}
+
+func (s storePublisher) put(file, relPath string) error {
+	key := path.Join(s.prefix, relPath)
+	verboseF("uploading %v\n", key)
+	if err := s.store.putObject(key, file); err != nil {
+		return fmt.Errorf("failed to upload %v: %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memStore is an objectStore keeping the uploaded objects in memory.
+type memStore struct {
+	mu       sync.Mutex
+	existing map[string]struct{}
+	uploaded []string
+	objects  map[string]string
+	fail     map[string]bool
+}
+
+func (m *memStore) url(prefix string) string {
+	return "mem://" + prefix
+}
+
+func (m *memStore) listKeys(prefix string) (map[string]struct{}, error) {
+	return m.existing, nil
+}
+
+func (m *memStore) putObject(key, file string) error {
+	if m.fail[key] {
+		return fmt.Errorf("failed to put %v", key)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploaded = append(m.uploaded, key)
+	m.objects[key] = string(data)
+	return nil
+}
+
+func TestPublishObjects(t *testing.T) {
+	src := t.TempDir() + "/gop_dependencies.zip"
+	writeTestArchive(t, src,
+		"cache/download/example.com/a/@v/list", "v1.1.0\n",
+		"cache/download/example.com/a/@v/v1.1.0.info", `{"Version":"v1.1.0"}`,
+		"cache/download/example.com/a/@v/v1.1.0.mod", "module example.com/a\n",
+		"cache/download/example.com/a/@v/v1.1.0.zip", moduleZip(t, "example.com/a", "v1.1.0"),
+		"cache/download/example.com/a/@v/v1.1.0.lock", "",
+		"cache/download/example.com/b/@v/list", "v1.0.0\n",
+		"cache/download/example.com/b/@v/v1.0.0.info", `{"Version":"v1.0.0"}`,
+		"cache/download/example.com/b/@v/v1.0.0.mod", "module example.com/b\n",
+		"cache/download/example.com/b/@v/v1.0.0.zip", moduleZip(t, "example.com/b", "v1.0.0"),
+	)
+
+	store := &memStore{
+		existing: map[string]struct{}{
+			"example.com/a/@v/v1.0.0.mod": {},
+			"example.com/b/@v/v1.0.0.mod": {},
+			"example.com/b/@v/v1.0.0.zip": {},
+		},
+		objects: map[string]string{},
+	}
+	var p publishCmd
+	p.PosArgs.Archive = src
+	if err := publishObjects(p, store, "/proxy/", false); err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded := append([]string(nil), store.uploaded...)
+	sort.Strings(uploaded)
+	want := []string{
+		"proxy/example.com/a/@v/list",
+		"proxy/example.com/a/@v/v1.1.0.info",
+		"proxy/example.com/a/@v/v1.1.0.mod",
+		"proxy/example.com/a/@v/v1.1.0.zip",
+		"proxy/example.com/b/@v/list",
+		"proxy/example.com/b/@v/v1.0.0.info",
+	}
+	if strings.Join(uploaded, ",") != strings.Join(want, ",") {
+		t.Errorf("uploaded %v, want %v", uploaded, want)
+	}
+
+	// The list file of a module is uploaded after its other files
+	last := map[string]string{}
+	for _, key := range store.uploaded {
+		last[path.Dir(key)] = path.Base(key)
+	}
+	for dir, name := range last {
+		if name != "list" {
+			t.Errorf("last upload of %v is %v, want list", dir, name)
+		}
+	}
+
+	if got := store.objects["proxy/example.com/a/@v/list"]; got != "v1.0.0\nv1.1.0\n" {
+		t.Errorf("list of example.com/a = %q, want the versions of the store and the archive", got)
+	}
+}
+
+func TestPublishObjectsKeepGoing(t *testing.T) {
+	src := t.TempDir() + "/gop_dependencies.zip"
+	writeTestArchive(t, src,
+		"cache/download/example.com/a/@v/v1.0.0.mod", "module example.com/a\n",
+		"cache/download/example.com/b/@v/v1.0.0.mod", "module example.com/b\n",
+	)
+
+	store := &memStore{
+		objects: map[string]string{},
+		fail:    map[string]bool{"example.com/a/@v/v1.0.0.mod": true},
+	}
+	p := publishCmd{KeepGoing: true}
+	p.PosArgs.Archive = src
+	err := publishObjects(p, store, "", false)
+	if err == nil || !strings.Contains(err.Error(), "example.com/a") {
+		t.Fatalf("publishObjects() error = %v, want the failure of example.com/a", err)
+	}
+	if _, ok := store.objects["example.com/b/@v/v1.0.0.mod"]; !ok {
+		t.Errorf("example.com/b wasn't uploaded with --keep-going")
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// ValidateCmd checks that a published proxy folder can be consumed by go.
+type ValidateCmd struct {
+	Module  []string `short:"m" long:"module" description:"Modules to validate (github.com/jessevdk/go-flags@v1.4.0), all modules of the folder are validated if omitted."`
+	Cache   string   `long:"cache" description:"File to cache the hashes of module zips, unchanged zips are not hashed again."`
+	PosArgs struct {
+		Folder string `positional-arg-name:"FOLDER" description:"Path to the published proxy folder."`
+	} `positional-args:"yes" required:"1"`
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (v *ValidateCmd) Execute(args []string) error {
+	log.SetPrefix("Validate: ")
+	checkGo()
+
+	if !folderExists(v.PosArgs.Folder) {
+		return fmt.Errorf("proxy folder not found: %v", v.PosArgs.Folder)
+	}
+
+	modules := v.Module
+	if len(modules) == 0 {
+		log.Println("collecting modules")
+		var err error
+		if modules, err = listProxyModules(v.PosArgs.Folder); err != nil {
+			return fmt.Errorf("failed to collect modules: %w", err)
+		}
+	}
+
+	var cache *hashCache
+	if v.Cache != "" {
+		var err error
+		if cache, err = loadHashCache(v.Cache); err != nil {
+			return err
+		}
+	}
+
+	log.Println("verifying module zip hashes")
+	checked, mismatches, err := verifyZipHashes(v.PosArgs.Folder, cache)
+	if err != nil {
+		return fmt.Errorf("failed to verify module zip hashes: %w", err)
+	}
+	for _, m := range mismatches {
+		log.Println(errorRedPrefix, "corrupted module zip:", color.RedString(m))
+	}
+	verboseF("verified %v module zips\n", checked)
+
+	if cache != nil {
+		log.Printf("skipped %v of %v unchanged module zips\n", cache.skipped, checked)
+		if err := cache.save(); err != nil {
+			log.Println(errorRedPrefix, "failed to save hash cache:", err)
+		}
+	}
+
+	workDir, cleanFn := createTempWorkDir()
+	defer cleanFn()
+
+	modCache := filepath.Join(workDir, "modcache")
+	if err := os.Mkdir(modCache, 0774); err != nil {
+		return fmt.Errorf("failed to create mod cache directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(workDir, "go.mod"), []byte(packager.TempGoMod(packager.DefaultGoVersion)), 0664); err != nil {
+		return fmt.Errorf("failed to write go.mod file: %w", err)
+	}
+
+	proxy := "GOPROXY=" + fileURL(v.PosArgs.Folder)
+	log.Printf("validating %v modules against %v\n", len(modules), color.BlueString(proxy))
+
+	var failed []string
+	for _, m := range modules {
+		cmd := getGoCommand(workDir, modCache, "mod", "download", m)
+		cmd.Env = append(cmd.Env, proxy, "GOSUMDB=off", "GOFLAGS=-mod=mod")
+
+		verboseF("validating module: %v\n", color.BlueString(m))
+		if _, err := packager.RunGoCommand(cmd); err != nil {
+			log.Printf("failed to resolve module: %v\n", color.RedString(m))
+			verboseF("%v: \n%v\n", color.RedString("error"), err)
+			failed = append(failed, m)
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%v of %v modules failed to resolve: %v", len(failed), len(modules), strings.Join(failed, ", "))
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("%v of %v module zips don't match their .ziphash", len(mismatches), checked)
+	}
+
+	log.Println("all modules resolved:", color.GreenString("%v", len(modules)))
+	return nil
+}
+
+// listProxyModules returns all module@version found in the list files of a proxy folder.
+func listProxyModules(folder string) (modules []string, err error) {
+	err = filepath.Walk(folder, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || info.Name() != "list" || filepath.Base(filepath.Dir(path)) != "@v" {
+			return nil
+		}
+
+		modName := packager.UnescapePath(packager.RelSlashPath(folder, filepath.Dir(filepath.Dir(path))))
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if version := strings.TrimSpace(scanner.Text()); version != "" {
+				modules = append(modules, modName+"@"+version)
+			}
+		}
+		return scanner.Err()
+	})
+
+	return modules, err
+}
+
+// fileURL returns the file:// url of path, as used in GOPROXY.
+func fileURL(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+
+	path = filepath.ToSlash(path)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return "file://" + path
+}
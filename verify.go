@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-sharp/color"
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// VerifyCmd checks an archive for corrupted or tampered module files.
+type VerifyCmd struct {
+	GoSum   string `long:"go-sum" description:"Additionally compare the hashes of the modules with the entries of the go.sum file."`
+	Cache   string `long:"cache" description:"File to cache the hashes of module zips, unchanged zips are not hashed again."`
+	PosArgs struct {
+		Archive string `positional-arg-name:"ARCHIVE" description:"Path to archive with dependencies."`
+	} `positional-args:"yes" required:"1"`
+}
+
+// Execute will be called for the last active (sub)command. The
+// args argument contains the remaining command line arguments. The
+// error that Execute returns will be eventually passed out of the
+// Parse method of the Parser.
+func (v *VerifyCmd) Execute(args []string) error {
+	log.SetPrefix("Verify: ")
+
+	var sums map[string]string
+	if v.GoSum != "" {
+		var err error
+		if sums, err = readGoSum(v.GoSum); err != nil {
+			return fmt.Errorf("failed to read go.sum: %w", err)
+		}
+	}
+
+	var cache *hashCache
+	if v.Cache != "" {
+		var err error
+		if cache, err = loadHashCache(v.Cache); err != nil {
+			return err
+		}
+	}
+
+	workDir, cleanFn := createTempWorkDir()
+	defer cleanFn()
+
+	log.Println("extracting archive")
+	if err := extractZipArchive(v.PosArgs.Archive, workDir); err != nil {
+		return fmt.Errorf("failed to extract archive: %w", err)
+	}
+
+	log.Println("verifying module zip hashes")
+	dlDir := filepath.Join(workDir, "cache", "download")
+	checked, mismatches, err := verifyZipHashes(dlDir, cache)
+	if err != nil {
+		return fmt.Errorf("failed to verify module zip hashes: %w", err)
+	}
+	verboseF("verified %v module zips\n", checked)
+
+	// The extracted zips keep the modification time of the archive entries, so the cache
+	// entries of unchanged zips still match
+	if cache != nil {
+		log.Printf("skipped %v of %v unchanged module zips\n", cache.skipped, checked)
+		if err := cache.save(); err != nil {
+			log.Println(errorRedPrefix, "failed to save hash cache:", err)
+		}
+	}
+
+	listMismatches, err := verifyListFiles(dlDir)
+	if err != nil {
+		return fmt.Errorf("failed to verify list files: %w", err)
+	}
+	mismatches = append(mismatches, listMismatches...)
+
+	mods, err := packager.CollectCacheModules(workDir)
+	if err != nil {
+		return fmt.Errorf("failed to collect modules: %w", err)
+	}
+	if sums != nil {
+		log.Println("comparing hashes with go.sum")
+		mismatches = append(mismatches, compareGoSum(mods, sums)...)
+	}
+
+	for _, m := range mismatches {
+		log.Println(errorRedPrefix, color.RedString(m))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("archive verification failed with %v mismatches", len(mismatches))
+	}
+
+	log.Printf("verified %v modules: %v\n", len(mods), color.GreenString("ok"))
+	return nil
+}
+
+// verifyListFiles checks that every version of a list file has a .mod file in the same directory.
+func verifyListFiles(dlDir string) (mismatches []string, err error) {
+	err = filepath.Walk(dlDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || info.Name() != "list" || filepath.Base(filepath.Dir(path)) != "@v" {
+			return nil
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		relPath := packager.RelSlashPath(dlDir, path)
+		for _, version := range strings.Fields(string(data)) {
+			if !fileExists(filepath.Join(filepath.Dir(path), packager.EscapePath(version)+".mod")) {
+				mismatches = append(mismatches, fmt.Sprintf("%v: listed version %v has no .mod file", relPath, version))
+			}
+		}
+		return nil
+	})
+	return mismatches, err
+}
+
+// readGoSum reads the go.sum file into a map of "<module> <version>[/go.mod]" to hash.
+func readGoSum(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	sums := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		sums[fields[0]+" "+fields[1]] = fields[2]
+	}
+	return sums, scanner.Err()
+}
+
+// compareGoSum compares the hashes of the module zips and .mod files with the go.sum entries.
+// Modules without go.sum entry are not checked.
+func compareGoSum(mods []packager.Module, sums map[string]string) (mismatches []string) {
+	for _, m := range mods {
+		if want, ok := sums[m.Path+" "+m.Version+"/go.mod"]; ok && m.GoModSum != want {
+			mismatches = append(mismatches, fmt.Sprintf("%v@%v: go.mod hash %v, go.sum has %v", m.Path, m.Version, m.GoModSum, want))
+		}
+
+		// The .ziphash files are already verified against the zips, so Sum is the hash of the zip
+		if want, ok := sums[m.Path+" "+m.Version]; ok && m.Zip != "" && m.Sum != want {
+			mismatches = append(mismatches, fmt.Sprintf("%v@%v: zip hash %v, go.sum has %v", m.Path, m.Version, m.Sum, want))
+		}
+	}
+	return mismatches
+}
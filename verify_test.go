@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCache(t *testing.T) {
+	dir := t.TempDir()
+	zipF := writeHashedModule(t, filepath.Join(dir, "cache", "download"), "example.com/a", "v1.0.0")
+	hash, err := os.ReadFile(strings.TrimSuffix(zipF, ".zip") + ".ziphash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	src := filepath.Join(dir, "gop_dependencies.zip")
+	writeTestArchive(t, src,
+		"cache/download/example.com/a/@v/list", "v1.0.0\n",
+		"cache/download/example.com/a/@v/v1.0.0.mod", "module example.com/a\n",
+		"cache/download/example.com/a/@v/v1.0.0.zip", moduleZip(t, "example.com/a", "v1.0.0"),
+		"cache/download/example.com/a/@v/v1.0.0.ziphash", string(hash),
+	)
+
+	var buf bytes.Buffer
+	w := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(w)
+
+	v := VerifyCmd{Cache: filepath.Join(dir, "hashes.json")}
+	v.PosArgs.Archive = src
+	for run := 0; run < 2; run++ {
+		if err := v.Execute(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cache, err := loadHashCache(v.Cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, ok := cache.Entries["example.com/a/@v/v1.0.0.zip"]; !ok || e.Hash != strings.TrimSpace(string(hash)) {
+		t.Errorf("cache entries = %v, want the hash of example.com/a/@v/v1.0.0.zip", cache.Entries)
+	}
+	if !strings.Contains(buf.String(), "skipped 0 of 1 unchanged module zips") || !strings.Contains(buf.String(), "skipped 1 of 1 unchanged module zips") {
+		t.Errorf("log doesn't report the skipped zips of both runs:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// hashCache records the hashes of module zips, so unchanged zips don't have to be hashed again.
+type hashCache struct {
+	file    string
+	Entries map[string]hashCacheEntry `json:"entries"`
+	skipped int
+}
+
+type hashCacheEntry struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	Hash    string    `json:"hash"`
+}
+
+// loadHashCache reads the hash cache from file, a missing file results in an empty cache.
+func loadHashCache(file string) (*hashCache, error) {
+	c := &hashCache{file: file, Entries: map[string]hashCacheEntry{}}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid hash cache %v: %w", file, err)
+	}
+	if c.Entries == nil {
+		c.Entries = map[string]hashCacheEntry{}
+	}
+	return c, nil
+}
+
+func (c *hashCache) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.file, data, 0664)
+}
+
+// hash returns the cached hash of file if its size and modification time are unchanged,
+// otherwise the hash is computed and stored in the cache.
+func (c *hashCache) hash(file, key string) (string, error) {
+	if c == nil {
+		return packager.HashZip(file)
+	}
+
+	fi, err := os.Stat(file)
+	if err != nil {
+		return "", err
+	}
+
+	if e, ok := c.Entries[key]; ok && e.Size == fi.Size() && e.ModTime.Equal(fi.ModTime()) {
+		c.skipped++
+		return e.Hash, nil
+	}
+
+	h, err := packager.HashZip(file)
+	if err != nil {
+		return "", err
+	}
+	c.Entries[key] = hashCacheEntry{Size: fi.Size(), ModTime: fi.ModTime(), Hash: h}
+	return h, nil
+}
+
+// verifyZipHashes compares every module zip below root which has a .ziphash file with
+// its recorded hash. It returns the number of checked zips and the mismatching ones.
+// If cache is not nil, unchanged zips are not hashed again.
+func verifyZipHashes(root string, cache *hashCache) (checked int, mismatches []string, err error) {
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".ziphash") {
+			return nil
+		}
+
+		want, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		zipF := strings.TrimSuffix(path, ".ziphash") + ".zip"
+		relPath := packager.RelSlashPath(root, zipF)
+		got, err := cache.hash(zipF, relPath)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+
+		checked++
+		if err != nil {
+			mismatches = append(mismatches, fmt.Sprintf("%v: %v", relPath, err))
+		} else if got != strings.TrimSpace(string(want)) {
+			mismatches = append(mismatches, fmt.Sprintf("%v: hash %v, expected %v", relPath, got, strings.TrimSpace(string(want))))
+		}
+		return nil
+	})
+
+	return checked, mismatches, err
+}
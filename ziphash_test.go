@@ -0,0 +1,131 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-sharp/go-offline-packager/packager"
+)
+
+// writeHashedModule writes the module zip of mod@version with its .ziphash file below root.
+func writeHashedModule(t *testing.T, root, mod, version string) string {
+	t.Helper()
+	dir := filepath.Join(root, filepath.FromSlash(packager.EscapePath(mod)), "@v")
+	if err := os.MkdirAll(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	zipF := filepath.Join(dir, version+".zip")
+	if err := os.WriteFile(zipF, []byte(moduleZip(t, mod, version)), 0666); err != nil {
+		t.Fatal(err)
+	}
+	hash, err := packager.HashZip(zipF)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, version+".ziphash"), []byte(hash+"\n"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return zipF
+}
+
+func TestVerifyZipHashes(t *testing.T) {
+	root := t.TempDir()
+	writeHashedModule(t, root, "example.com/a", "v1.0.0")
+	writeHashedModule(t, root, "example.com/B", "v1.1.0")
+
+	checked, mismatches, err := verifyZipHashes(root, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checked != 2 || len(mismatches) != 0 {
+		t.Fatalf("checked %v zips with mismatches %q, want 2 without mismatches", checked, mismatches)
+	}
+}
+
+func TestVerifyZipHashesCorrupted(t *testing.T) {
+	tests := []struct {
+		name    string
+		corrupt func(t *testing.T, zipF string)
+		want    string
+	}{
+		{name: "truncated", want: "zip: not a valid zip file", corrupt: func(t *testing.T, zipF string) {
+			if err := os.Truncate(zipF, 10); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{name: "changed content", want: "expected h1:", corrupt: func(t *testing.T, zipF string) {
+			if err := os.WriteFile(zipF, []byte(moduleZip(t, "example.com/a", "v1.0.1")), 0666); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			zipF := writeHashedModule(t, root, "example.com/a", "v1.0.0")
+			writeHashedModule(t, root, "example.com/b", "v1.0.0")
+			tt.corrupt(t, zipF)
+
+			checked, mismatches, err := verifyZipHashes(root, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if checked != 2 || len(mismatches) != 1 {
+				t.Fatalf("checked %v zips with mismatches %q, want 2 with 1 mismatch", checked, mismatches)
+			}
+			if !strings.HasPrefix(mismatches[0], "example.com/a/@v/v1.0.0.zip: ") || !strings.Contains(mismatches[0], tt.want) {
+				t.Errorf("mismatch = %q, want the zip and %q", mismatches[0], tt.want)
+			}
+		})
+	}
+}
+
+// TestRelativeFolderArguments passes the folders like ./dir and dir/, which filepath.Walk
+// returns cleaned paths for.
+func TestRelativeFolderArguments(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	writeHashedModule(t, "proxy", "example.com/A", "v1.0.0")
+	for name, content := range map[string]string{"list": "v1.0.0\n", "v1.0.0.mod": "module example.com/A\n"} {
+		if err := os.WriteFile(filepath.Join("proxy", "example.com", "!a", "@v", name), []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, folder := range []string{"./proxy", "proxy/", "./proxy/"} {
+		modules, err := listProxyModules(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(modules) != 1 || modules[0] != "example.com/A@v1.0.0" {
+			t.Errorf("listProxyModules(%q) = %q, want example.com/A@v1.0.0", folder, modules)
+		}
+
+		cache := &hashCache{Entries: map[string]hashCacheEntry{}}
+		if _, _, err := verifyZipHashes(folder, cache); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := cache.Entries["example.com/!a/@v/v1.0.0.zip"]; !ok {
+			t.Errorf("verifyZipHashes(%q) cached %v, want the key example.com/!a/@v/v1.0.0.zip", folder, cache.Entries)
+		}
+
+		mismatches, err := verifyListFiles(folder)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mismatches) != 0 {
+			t.Errorf("verifyListFiles(%q) = %q, want no mismatches", folder, mismatches)
+		}
+	}
+}